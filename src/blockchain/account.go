@@ -1,6 +1,8 @@
 package blockchain
 
 type Account struct {
-	Address string
-	Balance uint64
+	Address  string
+	Balance  uint64 // spendable coins
+	Immature uint64 // coinbase rewards not spendable yet
+	Nonce    uint64 // nonce expected on the next transaction sent from the account
 }
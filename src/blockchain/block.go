@@ -1,44 +1,87 @@
 package blockchain
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"ketcoin/src/crypto"
+	"strings"
 	"time"
 )
 
+// Output credits Amount to Address.
+type Output struct {
+	Address string
+	Amount  uint64
+}
+
+// OutPoint designates the output at Index of the transaction with hash Hash.
+type OutPoint struct {
+	Hash  string
+	Index int
+}
+
+func (o OutPoint) String() string {
+	return fmt.Sprintf("%s:%d", o.Hash, o.Index)
+}
+
+// Transaction pays each of its outputs from the sender's coins under a single signature.
+// On UTXO ledgers, the coins are the outputs listed in Inputs, which must sum to the outputs and the fee.
 type Transaction struct {
 	Sender    string
-	Receiver  string
-	Amount    uint64
+	Inputs    []OutPoint `json:",omitempty"` // only used by UTXO ledgers
+	Outputs   []Output
+	Fee       uint64 // paid by the sender to the miner of the block
+	Nonce     uint64 // must equal the sender's account nonce when executed
 	Timestamp time.Time
-	Signature *crypto.MssSignature
-	Hash      string
+	// LockHeight is the lowest index of a block including the transaction and ExpiryHeight the highest one.
+	// LockTime is the lowest timestamp of the parent of that block, which unlike the block's own timestamp
+	// can't be set ahead by its miner, in whole seconds. Zero values don't restrict anything.
+	LockHeight   uint64 `json:",omitempty"`
+	LockTime     time.Time
+	ExpiryHeight uint64 `json:",omitempty"`
+	Data         []byte `json:",omitempty"` // memo of at most MAX_DATA_SIZE bytes, indexed by nodes
+	Signature    *crypto.MssSignature
+	Witness      *Witness `json:",omitempty"` // replaces the signature when the sender is a script address
+	Hash         string
 }
 
 type Block struct {
-	Index        uint64
-	Hash         string
-	PrevHash     string
-	Timestamp    time.Time
-	Txns         []Transaction
-	StateRoot    string
-	Nonce        int
-	Reward       int
-	MinerAddress string
+	Index      uint64
+	Hash       string
+	PrevHash   string
+	Timestamp  time.Time
+	Txns       []Transaction // the coinbase comes first
+	StateRoot  string
+	Nonce      int
+	Difficulty int // number of leading zero hex digits of the hash
 }
 
 func (b *Block) prettyPrint() string {
-	s := fmt.Sprintf("Block %d@%d hash %s\n", b.Index, b.Timestamp, b.Hash)
+	s := fmt.Sprintf("Block %d@%d hash %s\n", b.Index, b.Timestamp.Unix(), b.Hash)
 	for i := 0; i < len(b.Txns); i++ {
-		s += fmt.Sprintf("txn %d from %s to %s : %s\n", i, b.Txns[i].Sender, b.Txns[i].Receiver, b.Txns[i].Amount)
+		for _, o := range b.Txns[i].Outputs {
+			s += fmt.Sprintf("txn %d from %s to %s : %d\n", i, b.Txns[i].Sender, o.Address, o.Amount)
+		}
 	}
 	return s
 }
 
+// toString covers every field of the Block but its hash, so that competing blocks never share a hash.
 func (b *Block) toString() string {
-	return fmt.Sprintf("%d%s%d", b.Index, b.PrevHash, b.Nonce)
+	return fmt.Sprintf("%d%s%d%s%s%d%d", b.Index, b.PrevHash, b.Timestamp.UnixNano(), b.txnsHash(), b.StateRoot, b.Nonce, b.Difficulty)
+}
+
+func (b *Block) txnsHash() string {
+	s := ""
+	for _, t := range b.Txns {
+		s += t.Hash
+	}
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
 }
 
 // ComputeHash computes the Block's hash. Used in mining.
@@ -47,8 +90,89 @@ func (b *Block) ComputeHash() string {
 	return hex.EncodeToString(h[:])
 }
 
+// MeetsDifficulty reports whether the Block's hash has as many leading zeros as its Difficulty requires.
+func (b *Block) MeetsDifficulty() bool {
+	return strings.HasPrefix(b.ComputeHash(), strings.Repeat("0", b.Difficulty))
+}
+
+// ComputeHash hashes every field of the Transaction but its signature, witness and hash. Numbers are encoded on
+// 8 bytes and strings are prefixed with their length, so that different transactions never share an encoding.
 func (t *Transaction) ComputeHash() string {
-	s := fmt.Sprintf("%s%s%d%d", t.Sender, t.Receiver, t.Amount, t.Timestamp.Unix())
-	h := sha256.Sum256([]byte(s))
+	var buf bytes.Buffer
+	writeUint := func(v uint64) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	writeBytes := func(data []byte) {
+		writeUint(uint64(len(data)))
+		buf.Write(data)
+	}
+
+	writeBytes([]byte(t.Sender))
+	writeUint(uint64(len(t.Inputs)))
+	for _, in := range t.Inputs {
+		writeBytes([]byte(in.Hash))
+		writeUint(uint64(in.Index))
+	}
+	writeUint(uint64(len(t.Outputs)))
+	for _, o := range t.Outputs {
+		writeBytes([]byte(o.Address))
+		writeUint(o.Amount)
+	}
+	writeUint(t.Fee)
+	writeUint(t.Nonce)
+	writeUint(uint64(t.Timestamp.Unix()))
+	writeUint(t.LockHeight)
+	writeUint(uint64(t.LockTime.Unix()))
+	writeUint(t.ExpiryHeight)
+	writeBytes(t.Data)
+	h := sha256.Sum256(buf.Bytes())
 	return hex.EncodeToString(h[:])
 }
+
+// Size returns the size in bytes of the encoded Transaction.
+func (t *Transaction) Size() int {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+// FeeRate returns the fee paid per byte of the Transaction.
+func (t *Transaction) FeeRate() float64 {
+	return float64(t.Fee) / float64(t.Size())
+}
+
+// Fees sums the fees of the Block's transactions.
+func (b *Block) Fees() uint64 {
+	var fees uint64
+	for _, t := range b.Txns {
+		fees += t.Fee
+	}
+	return fees
+}
+
+// NewCoinbase creates the transaction paying amount to the miner of the block at index.
+// Its nonce is the block index, which keeps coinbase hashes unique.
+func NewCoinbase(index uint64, miner string, amount uint64) Transaction {
+	t := Transaction{
+		Sender:  "",
+		Outputs: []Output{{Address: miner, Amount: amount}},
+		Nonce:   index,
+	}
+	t.Hash = t.ComputeHash()
+	return t
+}
+
+// IsCoinbase reports whether the Transaction creates coins rather than moving them.
+func (t *Transaction) IsCoinbase() bool {
+	return t.Sender == ""
+}
+
+// Coinbase returns the coinbase of the Block, or nil if it has none.
+func (b *Block) Coinbase() *Transaction {
+	if len(b.Txns) == 0 || !b.Txns[0].IsCoinbase() {
+		return nil
+	}
+	return &b.Txns[0]
+}
@@ -1,76 +1,652 @@
 package blockchain
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"sync"
 	"time"
 )
 
-const BLOCK_REWARD = 32
+var (
+	ErrKnownBlock   = errors.New("block already known")
+	ErrOrphanBlock  = errors.New("block parent unknown, keeping it as orphan")
+	ErrInvalidBlock = errors.New("block invalid")
+	ErrWrongGenesis = errors.New("genesis block does not match network")
+)
+
+const (
+	MAX_ORPHANS       = 100 // orphan blocks kept waiting for their parent
+	ORPHAN_EXPIRY     = 20 * time.Minute
+	SNAPSHOT_INTERVAL = 50 // main chain changes between two ledger snapshots
+)
 
 type Blockchain struct {
 	mutex    sync.RWMutex
-	Chain    []*Block
-	Accounts map[string]*Account
-}
-
-// Init creates a genesis block.
-func (bc *Blockchain) Init(a *Account) {
-	b := &Block{
-		Index:        0,
-		PrevHash:     "",
-		Timestamp:    time.Now(),
-		Txns:         nil,
-		Nonce:        0,
-		Reward:       BLOCK_REWARD,
-		MinerAddress: a.Address,
-	}
-	b.Hash = b.ComputeHash()
-	// Add genesis block to chain
-	bc.Chain = append(bc.Chain, b)
-	// Initialize map and add node's account to address => account mapping
-	bc.Accounts = make(map[string]*Account)
-	bc.Accounts[a.Address] = a
-	// Add block reward to node's account
-	a.Balance += BLOCK_REWARD
-}
-
-func (bc *Blockchain) IsValid() bool {
+	Chain    []*Block              // main chain, from genesis to tip
+	ledger   Ledger                // coins owned at the tip
+	nodes    map[string]*blockNode // every known block by hash, side branches included
+	orphans  map[string][]orphan   // blocks waiting for their parent, by PrevHash
+	norphans int                   // number of blocks in orphans
+	memos    memoIndex             // main chain transactions carrying data
+	store    *Store                // nil when the blockchain lives only in memory
+	unsaved  int                   // main chain changes since the last ledger snapshot
+	params   *ChainParams
+}
+
+// blockNode places a Block in the block tree.
+type blockNode struct {
+	block    *Block
+	parent   *blockNode
+	children []*blockNode
+	work     *big.Int // cumulative work from genesis up to and including block
+	undo     Undo     // set while block is on the main chain
+}
+
+type orphan struct {
+	block *Block
+	added time.Time
+}
+
+// Init starts the blockchain from the genesis block of params.
+func (bc *Blockchain) Init(params *ChainParams) {
+	bc.params = params
+	bc.ledger = params.newLedger()
+	bc.reset(params.Genesis)
+}
+
+// Open resumes the blockchain from the blocks and ledger held by s, or starts it from the genesis block
+// of params when s is empty. Blocks and state changes are written to s from then on.
+func (bc *Blockchain) Open(s *Store, params *ChainParams) error {
+	bc.Lock()
+	defer bc.Unlock()
+	blocks, err := s.LoadBlocks()
+	if err != nil {
+		return err
+	}
+	bc.Init(params)
+	bc.store = s
+	if len(blocks) == 0 {
+		bc.persistBlock(params.Genesis)
+		bc.writeState()
+		return nil
+	}
+	if blocks[0].Hash != params.GenesisHash {
+		return fmt.Errorf("%w : stored blocks belong to another network than %s", ErrWrongGenesis, params.Name)
+	}
+
+	best := bc.tip()
+	for _, b := range blocks[1:] {
+		parent, exists := bc.nodes[b.PrevHash]
+		if !exists {
+			log.Printf("Stored block %s has no stored parent, skipping it", b.Hash)
+			continue
+		}
+		node := &blockNode{block: b, parent: parent, work: new(big.Int).Add(parent.work, params.Engine().Work(b))}
+		bc.insert(node)
+		if node.work.Cmp(best.work) > 0 {
+			best = node
+		}
+	}
+
+	snapshot, err := s.loadState()
+	var ledger Ledger
+	if err == nil {
+		ledger, err = params.decodeLedger(snapshot.Ledger)
+	}
+	var undo map[string]Undo
+	if err == nil {
+		undo, err = s.loadUndo()
+	}
+	tip, exists := bc.nodes[snapshotTip(snapshot)]
+	// the snapshot tip can't be disconnected without the undo data of its branch
+	for node := tip; err == nil && exists && node.parent != nil; node = node.parent {
+		_, exists = undo[node.block.Hash]
+	}
+	if err == nil && exists {
+		bc.Chain = bc.Chain[:0]
+		for node := tip; node != nil; node = node.parent {
+			node.undo = undo[node.block.Hash]
+			bc.Chain = append([]*Block{node.block}, bc.Chain...)
+		}
+		bc.ledger = ledger
+		for _, b := range bc.Chain {
+			bc.memos.add(b)
+		}
+	} else {
+		log.Println("No usable ledger stored, replaying blocks from genesis...")
+	}
+	log.Printf("Resuming from stored block %s at index %d", bc.tip().block.Hash, bc.tip().block.Index)
+
+	if best.work.Cmp(bc.tip().work) > 0 {
+		if err := bc.reorganize(best); err != nil {
+			log.Println("Error switching to best stored branch")
+			log.Println(err)
+		}
+	}
+	bc.writeState()
+	return nil
+}
+
+// Params returns the parameters of the network the blockchain belongs to.
+func (bc *Blockchain) Params() *ChainParams {
+	return bc.params
+}
+
+func snapshotTip(snapshot *stateSnapshot) string {
+	if snapshot == nil {
+		return ""
+	}
+	return snapshot.Tip
+}
+
+// Close closes the store, after which the blockchain is only kept in memory.
+func (bc *Blockchain) Close() error {
+	bc.Lock()
+	defer bc.Unlock()
+	if bc.store == nil {
+		return nil
+	}
+	if bc.unsaved > 0 {
+		bc.writeState()
+	}
+	err := bc.store.Close()
+	bc.store = nil
+	return err
+}
+
+// persistBlock writes b to the store, if any.
+func (bc *Blockchain) persistBlock(b *Block) {
+	if bc.store == nil {
+		return
+	}
+	if err := bc.store.WriteBlock(b); err != nil {
+		log.Println("Error writing block to store")
+		log.Println(err)
+	}
+}
+
+// persistUndo writes the undo data of node, which was just connected, to the store, if any.
+func (bc *Blockchain) persistUndo(node *blockNode) {
+	if bc.store == nil {
+		return
+	}
+	if err := bc.store.writeUndo(node.block.Hash, node.undo); err != nil {
+		log.Println("Error writing undo data to store")
+		log.Println(err)
+	}
+}
+
+// persistState records a change of the main chain, writing a snapshot of the ledger every SNAPSHOT_INTERVAL
+// changes. The blocks connected since the last snapshot are replayed when the store is opened again.
+func (bc *Blockchain) persistState() {
+	bc.unsaved++
+	if bc.unsaved >= SNAPSHOT_INTERVAL {
+		bc.writeState()
+	}
+}
+
+// writeState writes a snapshot of the ledger to the store, if any.
+func (bc *Blockchain) writeState() {
+	if bc.store == nil {
+		return
+	}
+	ledger, err := json.Marshal(bc.ledger)
+	if err != nil {
+		log.Println("Error encoding ledger")
+		log.Println(err)
+		return
+	}
+	snapshot := &stateSnapshot{
+		Tip:    bc.tip().block.Hash,
+		Ledger: ledger,
+	}
+	if err := bc.store.writeState(snapshot); err != nil {
+		log.Println("Error writing state to store")
+		log.Println(err)
+		return
+	}
+	bc.unsaved = 0
+}
+
+// reset makes genesis the root of an otherwise empty block tree.
+func (bc *Blockchain) reset(genesis *Block) {
+	bc.Chain = []*Block{genesis}
+	bc.nodes = map[string]*blockNode{
+		genesis.Hash: {block: genesis, work: bc.params.Engine().Work(genesis)},
+	}
+	bc.orphans = make(map[string][]orphan)
+	bc.norphans = 0
+	bc.memos = nil
+}
+
+// IsValid checks the headers of the Chain against params. Transactions and state roots are checked as blocks
+// are connected.
+func (bc *Blockchain) IsValid(params *ChainParams) bool {
+	// the Chain of a blockchain received from a peer may hold anything
+	for _, b := range bc.Chain {
+		if b == nil {
+			log.Println("Missing block in chain")
+			return false
+		}
+	}
+	if len(bc.Chain) == 0 || bc.Chain[0].Hash != params.GenesisHash {
+		return false
+	}
 	for i := 0; i < len(bc.Chain)-1; i++ {
-		if bc.Chain[i+1].Index-1 != bc.Chain[i].Index ||
-			bc.Chain[i+1].PrevHash != bc.Chain[i].Hash ||
-			bc.Chain[i].Hash != bc.Chain[i].ComputeHash() {
-			log.Printf("idxs : %d - %d\nh1 : \n%s\n%s\nh2 : \n%s\n%s\n", bc.Chain[i+1].Index-1, bc.Chain[i].Index, bc.Chain[i+1].PrevHash, bc.Chain[i].Hash, bc.Chain[i].Hash, bc.Chain[i].ComputeHash())
+		if err := checkHeader(bc.Chain[i+1], bc.Chain[i], params); err != nil {
+			log.Printf("Invalid header at index %d", bc.Chain[i+1].Index)
+			log.Println(err)
 			return false
 		}
 	}
 	return true
 }
 
-func (bc *Blockchain) AddBlock(b *Block) {
+// AddBlock inserts b in the block tree and switches the main chain to the branch with the most cumulative work.
+// It returns the blocks that joined and left the main chain.
+func (bc *Blockchain) AddBlock(b *Block) (connected, disconnected []*Block, err error) {
 	bc.Lock()
 	defer bc.Unlock()
-	bc.Chain = append(bc.Chain, b)
+	oldTip := bc.tip()
+	err = bc.addBlock(b)
+	connected, disconnected = bc.diff(oldTip, bc.tip())
+	if oldTip != bc.tip() {
+		bc.persistState()
+	}
+	return connected, disconnected, err
 }
 
-func (bc *Blockchain) ReplaceChain(other *Blockchain) {
+// ReplaceChain adds the blocks of other to the block tree, validating and executing each of them.
+// Only the blocks of other are used.
+func (bc *Blockchain) ReplaceChain(other *Blockchain) (connected, disconnected []*Block, err error) {
+	if len(other.Chain) == 0 || other.Chain[0] == nil {
+		return nil, nil, fmt.Errorf("%w : empty chain", ErrInvalidBlock)
+	}
+	if other.Chain[0].Hash != bc.params.GenesisHash {
+		return nil, nil, ErrWrongGenesis
+	}
 	bc.Lock()
 	defer bc.Unlock()
-	bc.Chain = other.Chain
-	bc.Accounts = other.Accounts //will this import accounts too?
+
+	oldTip := bc.tip()
+	for _, b := range other.Chain[1:] {
+		if b == nil {
+			err = fmt.Errorf("%w : missing block in chain", ErrInvalidBlock)
+			break
+		}
+		if err = bc.addBlock(b); err != nil && !errors.Is(err, ErrKnownBlock) {
+			break
+		}
+		err = nil
+	}
+	connected, disconnected = bc.diff(oldTip, bc.tip())
+	if oldTip != bc.tip() {
+		bc.persistState()
+	}
+	return connected, disconnected, err
 }
 
-func (bc *Blockchain) GetStateRoot() string {
-	s := ""
-	for _, acc := range bc.Accounts {
-		s += fmt.Sprintf("%s%d", acc.Address, acc.Balance)
+// addBlock stores b and any orphan waiting on it, then reorganizes if a stored block beats the tip.
+func (bc *Blockchain) addBlock(b *Block) error {
+	if _, exists := bc.nodes[b.Hash]; exists {
+		return ErrKnownBlock
+	}
+	if err := checkSeal(b, bc.params); err != nil {
+		return err
+	}
+	parent, exists := bc.nodes[b.PrevHash]
+	if !exists {
+		bc.addOrphan(b)
+		return ErrOrphanBlock
+	}
+	if err := checkHeader(b, parent.block, bc.params); err != nil {
+		return err
+	}
+
+	best := bc.tip()
+	pending := []*blockNode{{block: b, parent: parent}}
+	for len(pending) > 0 {
+		node := pending[0]
+		pending = pending[1:]
+		node.work = new(big.Int).Add(node.parent.work, bc.params.Engine().Work(node.block))
+		bc.insert(node)
+		if node.work.Cmp(best.work) > 0 {
+			best = node
+		}
+
+		for _, child := range bc.orphans[node.block.Hash] {
+			if checkHeader(child.block, node.block, bc.params) == nil {
+				pending = append(pending, &blockNode{block: child.block, parent: node})
+			}
+		}
+		bc.norphans -= len(bc.orphans[node.block.Hash])
+		delete(bc.orphans, node.block.Hash)
+	}
+
+	if best != bc.tip() {
+		if err := bc.reorganize(best); err != nil {
+			// b is fine if only one of its descendants failed
+			if _, kept := bc.nodes[b.Hash]; !kept {
+				return err
+			}
+			log.Println("Dropped invalid descendant of block", b.Hash)
+			log.Println(err)
+		}
+	}
+	return nil
+}
+
+// reorganize makes the branch ending at target the main chain. Blocks are disconnected down to the fork
+// point, then the new branch is connected. If a block of the new branch fails, it is dropped from the tree
+// along with its descendants, the previous main chain is restored and the chain switches to the remaining
+// block with the most work, which may be a valid ancestor of the failed one. The error of the failed block
+// is returned.
+func (bc *Blockchain) reorganize(target *blockNode) error {
+	fork := bc.findFork(bc.tip(), target)
+	var branch []*blockNode
+	for node := target; node != fork; node = node.parent {
+		branch = append([]*blockNode{node}, branch...)
+	}
+
+	var detached []*blockNode
+	for bc.tip() != fork {
+		detached = append(detached, bc.tip())
+		bc.disconnectBlock()
+	}
+
+	for i, node := range branch {
+		if err := bc.connectBlock(node); err != nil {
+			bc.prune(node)
+			for j := 0; j < i; j++ {
+				bc.disconnectBlock()
+			}
+			for j := len(detached) - 1; j >= 0; j-- {
+				bc.connectBlock(detached[j])
+			}
+			best := bc.tip()
+			for _, other := range bc.nodes {
+				if other.work.Cmp(best.work) > 0 {
+					best = other
+				}
+			}
+			if best != bc.tip() {
+				if err := bc.reorganize(best); err != nil {
+					log.Println("Error switching to best remaining branch")
+					log.Println(err)
+				}
+			}
+			return err
+		}
+	}
+
+	log.Printf("Switching main chain to %s at index %d", target.block.Hash, target.block.Index)
+	return nil
+}
+
+// ConnectBlock executes b on top of the main chain tip and makes it the new tip.
+// b must either be in the block tree already or extend the tip with a valid header.
+func (bc *Blockchain) ConnectBlock(b *Block) error {
+	bc.Lock()
+	defer bc.Unlock()
+	node, exists := bc.nodes[b.Hash]
+	if !exists {
+		tip := bc.tip()
+		if b.PrevHash != tip.block.Hash || b.Index != tip.block.Index+1 {
+			return fmt.Errorf("%w : block does not extend the tip", ErrInvalidBlock)
+		}
+		if err := checkHeader(b, tip.block, bc.params); err != nil {
+			return err
+		}
+		node = &blockNode{block: b, parent: tip, work: new(big.Int).Add(tip.work, bc.params.Engine().Work(b))}
+		if err := bc.connectBlock(node); err != nil {
+			return err
+		}
+		bc.insert(node)
+		bc.persistState()
+		return nil
+	}
+	if err := bc.connectBlock(node); err != nil {
+		return err
+	}
+	bc.persistState()
+	return nil
+}
+
+// DisconnectBlock reverts the main chain tip and returns it. The genesis block can't be disconnected.
+func (bc *Blockchain) DisconnectBlock() (*Block, error) {
+	bc.Lock()
+	defer bc.Unlock()
+	if len(bc.Chain) == 1 {
+		return nil, errors.New("cannot disconnect the genesis block")
+	}
+	b := bc.disconnectBlock()
+	bc.persistState()
+	return b, nil
+}
+
+func (bc *Blockchain) connectBlock(node *blockNode) error {
+	if node.parent != bc.tip() {
+		return fmt.Errorf("%w : block does not extend the tip", ErrInvalidBlock)
+	}
+	if err := checkTxns(node.block, node.parent.block, bc.params); err != nil {
+		return err
+	}
+	supply := bc.ledger.Supply()
+	undo, err := bc.ledger.ApplyBlock(node.block, bc.params, bc.maturingCoinbase(node.block.Index))
+	if err != nil {
+		return err
+	}
+	// transactions only move coins around, so a block adds exactly its subsidy to the supply
+	if bc.ledger.Supply() != supply+bc.params.Subsidy(node.block.Index) {
+		bc.ledger.RevertBlock(undo)
+		return fmt.Errorf("%w : block %d does not conserve supply", ErrInvalidBlock, node.block.Index)
+	}
+	if root := bc.ledger.Root(); root != node.block.StateRoot {
+		bc.ledger.RevertBlock(undo)
+		return fmt.Errorf("%w : state root mismatch at block %d", ErrInvalidBlock, node.block.Index)
+	}
+	node.undo = undo
+	bc.Chain = append(bc.Chain, node.block)
+	bc.memos.add(node.block)
+	// only blocks that were executed successfully are stored, each one after its parent
+	bc.persistBlock(node.block)
+	bc.persistUndo(node)
+	return nil
+}
+
+func (bc *Blockchain) disconnectBlock() *Block {
+	tip := bc.tip()
+	bc.ledger.RevertBlock(tip.undo)
+	tip.undo = Undo{}
+	bc.Chain = bc.Chain[:len(bc.Chain)-1]
+	bc.memos.remove(tip.block)
+	return tip.block
+}
+
+// findFork returns the last block two branches of the tree have in common.
+func (bc *Blockchain) findFork(a, b *blockNode) *blockNode {
+	for a != b {
+		if a.block.Index >= b.block.Index {
+			a = a.parent
+		} else {
+			b = b.parent
+		}
+	}
+	return a
+}
+
+// insert adds node to the block tree, under its parent.
+func (bc *Blockchain) insert(node *blockNode) {
+	bc.nodes[node.block.Hash] = node
+	node.parent.children = append(node.parent.children, node)
+}
+
+// prune removes node and all of its descendants from the block tree.
+func (bc *Blockchain) prune(node *blockNode) {
+	siblings := node.parent.children
+	for i, sibling := range siblings {
+		if sibling == node {
+			node.parent.children = append(siblings[:i:i], siblings[i+1:]...)
+			break
+		}
+	}
+	for pending := []*blockNode{node}; len(pending) > 0; pending = pending[1:] {
+		delete(bc.nodes, pending[0].block.Hash)
+		pending = append(pending, pending[0].children...)
+	}
+}
+
+// addOrphan keeps b until its parent arrives. Expired orphans are dropped first, then the oldest ones
+// if there are still MAX_ORPHANS of them.
+func (bc *Blockchain) addOrphan(b *Block) {
+	for _, other := range bc.orphans[b.PrevHash] {
+		if other.block.Hash == b.Hash {
+			return
+		}
+	}
+	if bc.norphans >= MAX_ORPHANS {
+		bc.evictOrphans(time.Now().Add(-ORPHAN_EXPIRY))
+	}
+	for bc.norphans >= MAX_ORPHANS {
+		oldest := time.Now()
+		for _, waiting := range bc.orphans {
+			for _, other := range waiting {
+				if other.added.Before(oldest) {
+					oldest = other.added
+				}
+			}
+		}
+		bc.evictOrphans(oldest.Add(1))
+	}
+	bc.orphans[b.PrevHash] = append(bc.orphans[b.PrevHash], orphan{block: b, added: time.Now()})
+	bc.norphans++
+}
+
+// evictOrphans drops the orphans added before t.
+func (bc *Blockchain) evictOrphans(t time.Time) {
+	for prevHash, waiting := range bc.orphans {
+		kept := waiting[:0]
+		for _, other := range waiting {
+			if other.added.Before(t) {
+				bc.norphans--
+			} else {
+				kept = append(kept, other)
+			}
+		}
+		if len(kept) == 0 {
+			delete(bc.orphans, prevHash)
+		} else {
+			bc.orphans[prevHash] = kept
+		}
+	}
+}
+
+// diff returns the blocks to connect and disconnect, in that order of application, to move from tip from to tip to.
+func (bc *Blockchain) diff(from, to *blockNode) (connected, disconnected []*Block) {
+	fork := bc.findFork(from, to)
+	for ; from != fork; from = from.parent {
+		disconnected = append(disconnected, from.block)
+	}
+	for ; to != fork; to = to.parent {
+		connected = append([]*Block{to.block}, connected...)
+	}
+	return connected, disconnected
+}
+
+// maturingCoinbase returns the coinbase of the main chain that becomes spendable in the block at index,
+// which must extend the tip.
+func (bc *Blockchain) maturingCoinbase(index uint64) *Transaction {
+	if index <= bc.params.CoinbaseMaturity {
+		return nil
+	}
+	return bc.Chain[index-bc.params.CoinbaseMaturity].Coinbase()
+}
+
+func (bc *Blockchain) tip() *blockNode {
+	return bc.nodes[bc.Chain[len(bc.Chain)-1].Hash]
+}
+
+// ComputeStateRoot returns the state root the ledger would have after executing b on top of the current tip.
+func (bc *Blockchain) ComputeStateRoot(b *Block) (string, error) {
+	bc.Lock()
+	defer bc.Unlock()
+	undo, err := bc.ledger.ApplyBlock(b, bc.params, bc.maturingCoinbase(b.Index))
+	if err != nil {
+		return "", err
+	}
+	defer bc.ledger.RevertBlock(undo)
+	return bc.ledger.Root(), nil
+}
+
+// FilterTxns returns, in order, the transactions of txns that can be executed one after the other in a block
+// extending the tip.
+func (bc *Blockchain) FilterTxns(txns []Transaction) []Transaction {
+	bc.Lock()
+	defer bc.Unlock()
+	tip := bc.tip().block
+	unlocked := make([]Transaction, 0, len(txns))
+	for i := range txns {
+		if txns[i].CheckLocks(tip.Index+1, tip.Timestamp) == nil {
+			unlocked = append(unlocked, txns[i])
+		}
 	}
-	h := sha256.Sum256([]byte(s))
+	return bc.ledger.FilterTxns(unlocked, tip.Index+1)
+}
 
-	return hex.EncodeToString(h[:])
+// CheckInputs checks that t can spend its inputs in a block extending the tip : on UTXO ledgers they must be
+// unspent outputs of the sender summing to the cost of t. Account ledgers don't use inputs.
+func (bc *Blockchain) CheckInputs(t *Transaction) error {
+	bc.Lock()
+	defer bc.Unlock()
+	s, ok := bc.ledger.(*UTXOSet)
+	if !ok {
+		return nil
+	}
+	undo, err := s.applyTransaction(t, bc.tip().block.Index+1)
+	s.revert(undo)
+	return err
+}
+
+// GetAccount returns what addr can spend, which is empty if the address never received coins.
+func (bc *Blockchain) GetAccount(addr string) Account {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.ledger.Account(addr)
+}
+
+// GetSupply returns the index of the tip and the coins in circulation at that point.
+func (bc *Blockchain) GetSupply() (uint64, uint64) {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.tip().block.Index, bc.ledger.Supply()
+}
+
+// FindMemos returns at most limit main chain transactions whose data starts with prefix.
+func (bc *Blockchain) FindMemos(prefix []byte, limit int) []MemoEntry {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.memos.find(prefix, limit)
+}
+
+func (bc *Blockchain) GetStateRoot() string {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.ledger.Root()
+}
+
+// ChainWork sums the work of the blocks in the Chain under the consensus engine of params.
+func (bc *Blockchain) ChainWork(params *ChainParams) *big.Int {
+	bc.RLock()
+	defer bc.RUnlock()
+	engine := params.Engine()
+	work := new(big.Int)
+	for _, b := range bc.Chain {
+		work.Add(work, engine.Work(b))
+	}
+	return work
 }
 
 // well-defined on a non-zero sized blockchain
@@ -86,7 +662,7 @@ func (bc *Blockchain) GetLastBlock() *Block {
 	return bc.Chain[len(bc.Chain)-1]
 }
 
-//Encapsulation mutex function to avoid blockchain encoding errors
+// Encapsulation mutex function to avoid blockchain encoding errors
 func (bc *Blockchain) Lock() {
 	bc.mutex.Lock()
 }
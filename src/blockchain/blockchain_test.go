@@ -0,0 +1,275 @@
+package blockchain_test
+
+import (
+	"encoding/json"
+	"errors"
+	"ketcoin/src/blockchain"
+	_ "ketcoin/src/consensus"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var params = blockchain.RegtestParams
+
+func newChain() *blockchain.Blockchain {
+	bc := &blockchain.Blockchain{}
+	bc.Init(params)
+	return bc
+}
+
+// copyChain returns a blockchain holding the main chain of bc up to the block at index, to mine a branch on.
+func copyChain(t *testing.T, bc *blockchain.Blockchain, index uint64) *blockchain.Blockchain {
+	t.Helper()
+	other := newChain()
+	for _, b := range bc.Chain[1 : index+1] {
+		if err := other.ConnectBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return other
+}
+
+// build returns a block paying miner on top of the tip of bc.
+func build(t *testing.T, bc *blockchain.Blockchain, miner string) *blockchain.Block {
+	t.Helper()
+	last := bc.GetLastBlock()
+	b := &blockchain.Block{Index: last.Index + 1, PrevHash: last.Hash, Timestamp: time.Now(), Difficulty: params.Difficulty}
+	b.Txns = []blockchain.Transaction{blockchain.NewCoinbase(b.Index, miner, params.Subsidy(b.Index))}
+	root, err := bc.ComputeStateRoot(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.StateRoot = root
+	b.Hash = b.ComputeHash()
+	return b
+}
+
+// mine builds a block paying miner on top of the tip of bc and connects it.
+func mine(t *testing.T, bc *blockchain.Blockchain, miner string) *blockchain.Block {
+	t.Helper()
+	b := build(t, bc, miner)
+	if err := bc.ConnectBlock(b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func hashes(blocks ...*blockchain.Block) []string {
+	h := make([]string, len(blocks))
+	for i, b := range blocks {
+		h[i] = b.Hash
+	}
+	return h
+}
+
+func owned(bc *blockchain.Blockchain, addr string) uint64 {
+	acc := bc.GetAccount(addr)
+	return acc.Balance + acc.Immature
+}
+
+func TestReorganizeToBranchWithMoreWork(t *testing.T) {
+	bc := newChain()
+	m1 := mine(t, bc, "bob")
+	m2 := mine(t, bc, "bob")
+	main, side := copyChain(t, bc, 2), copyChain(t, bc, 0)
+	a1, a2, a3 := mine(t, side, "alice"), mine(t, side, "alice"), mine(t, side, "alice")
+
+	for _, b := range []*blockchain.Block{a1, a2} {
+		if connected, disconnected, err := bc.AddBlock(b); err != nil || len(connected) > 0 || len(disconnected) > 0 {
+			t.Fatalf("side block %d switched the chain : %v", b.Index, err)
+		}
+	}
+	connected, disconnected, err := bc.AddBlock(a3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hashes(connected...), hashes(a1, a2, a3); !reflect.DeepEqual(got, want) {
+		t.Errorf("connected %v, expected %v", got, want)
+	}
+	if got, want := hashes(disconnected...), hashes(m2, m1); !reflect.DeepEqual(got, want) {
+		t.Errorf("disconnected %v, expected %v", got, want)
+	}
+	if owned(bc, "bob") != 0 || owned(bc, "alice") != 3*params.InitialSubsidy {
+		t.Errorf("ledger not switched : bob owns %d, alice %d", owned(bc, "bob"), owned(bc, "alice"))
+	}
+
+	// the first branch wins again once it has more work
+	for _, b := range []*blockchain.Block{mine(t, main, "bob"), mine(t, main, "bob")} {
+		if _, _, err := bc.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if bc.GetLastIndex() != 4 || owned(bc, "bob") != 4*params.InitialSubsidy || owned(bc, "alice") != 0 {
+		t.Errorf("chain didn't switch back : tip %d, bob owns %d, alice %d", bc.GetLastIndex(), owned(bc, "bob"), owned(bc, "alice"))
+	}
+}
+
+func TestReorganizeKeepsValidAncestorsOfInvalidBlock(t *testing.T) {
+	bc := newChain()
+	mine(t, bc, "bob")
+	side := copyChain(t, bc, 0)
+	a1, a2 := mine(t, side, "alice"), mine(t, side, "alice")
+	a3 := build(t, side, "alice")
+	a3.StateRoot = "bad"
+	a3.Hash = a3.ComputeHash()
+
+	for _, b := range []*blockchain.Block{a2, a3} {
+		if _, _, err := bc.AddBlock(b); !errors.Is(err, blockchain.ErrOrphanBlock) {
+			t.Fatalf("block %d returned %v, expected ErrOrphanBlock", b.Index, err)
+		}
+	}
+	connected, _, err := bc.AddBlock(a1)
+	if err != nil {
+		t.Fatalf("valid block returned %v", err)
+	}
+	if got, want := hashes(connected...), hashes(a1, a2); !reflect.DeepEqual(got, want) {
+		t.Errorf("connected %v, expected %v", got, want)
+	}
+	if bc.GetLastBlock().Hash != a2.Hash {
+		t.Errorf("tip is block %d, expected the valid side block 2", bc.GetLastIndex())
+	}
+	if _, _, err := bc.AddBlock(a3); !errors.Is(err, blockchain.ErrInvalidBlock) {
+		t.Errorf("invalid block returned %v, expected ErrInvalidBlock", err)
+	}
+	if bc.GetLastBlock().Hash != a2.Hash {
+		t.Errorf("invalid block moved the tip")
+	}
+}
+
+func TestOrphansConnectOnceParentArrives(t *testing.T) {
+	bc := newChain()
+	side := copyChain(t, bc, 0)
+	a1, a2, a3 := mine(t, side, "alice"), mine(t, side, "alice"), mine(t, side, "alice")
+
+	for _, b := range []*blockchain.Block{a3, a2, a3} {
+		if _, _, err := bc.AddBlock(b); !errors.Is(err, blockchain.ErrOrphanBlock) {
+			t.Fatalf("block %d returned %v, expected ErrOrphanBlock", b.Index, err)
+		}
+	}
+	connected, disconnected, err := bc.AddBlock(a1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hashes(connected...), hashes(a1, a2, a3); !reflect.DeepEqual(got, want) || len(disconnected) > 0 {
+		t.Errorf("connected %v and disconnected %d blocks, expected %v", got, len(disconnected), want)
+	}
+	if bc.GetStateRoot() != a3.StateRoot {
+		t.Errorf("state root doesn't match the tip")
+	}
+}
+
+func TestDisconnectAndReconnect(t *testing.T) {
+	bc := newChain()
+	if _, err := bc.DisconnectBlock(); err == nil {
+		t.Error("genesis block disconnected")
+	}
+	m1 := mine(t, bc, "bob")
+	m2 := mine(t, bc, "carol")
+
+	b, err := bc.DisconnectBlock()
+	if err != nil || b.Hash != m2.Hash {
+		t.Fatalf("disconnected %v : %v", b, err)
+	}
+	if bc.GetStateRoot() != m1.StateRoot || owned(bc, "carol") != 0 {
+		t.Errorf("ledger not reverted to block 1")
+	}
+	if err := bc.ConnectBlock(m2); err != nil {
+		t.Fatal(err)
+	}
+	if bc.GetLastBlock().Hash != m2.Hash || bc.GetStateRoot() != m2.StateRoot || owned(bc, "carol") != params.InitialSubsidy {
+		t.Errorf("ledger not restored to block 2")
+	}
+}
+
+func TestConnectBlockChecksHeader(t *testing.T) {
+	bc := newChain()
+	m1 := mine(t, bc, "bob")
+
+	altered := build(t, bc, "bob")
+	altered.StateRoot = m1.StateRoot
+	early := build(t, bc, "bob")
+	early.Timestamp = m1.Timestamp.Add(-time.Second)
+	early.Hash = early.ComputeHash()
+	future := build(t, bc, "bob")
+	future.Timestamp = time.Now().Add(blockchain.MAX_FUTURE_DRIFT + time.Hour)
+	future.Hash = future.ComputeHash()
+	stale := build(t, bc, "bob")
+	stale.PrevHash = params.GenesisHash
+	stale.Hash = stale.ComputeHash()
+
+	for name, b := range map[string]*blockchain.Block{"altered": altered, "early": early, "future": future, "stale": stale} {
+		if err := bc.ConnectBlock(b); !errors.Is(err, blockchain.ErrInvalidBlock) {
+			t.Errorf("%s block returned %v, expected ErrInvalidBlock", name, err)
+		}
+	}
+	if bc.GetLastBlock().Hash != m1.Hash || bc.GetStateRoot() != m1.StateRoot {
+		t.Errorf("invalid blocks changed the tip")
+	}
+}
+
+// TestReadWhileConnecting is meant for the race detector.
+func TestReadWhileConnecting(t *testing.T) {
+	bc := newChain()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			bc.GetStateRoot()
+			bc.GetSupply()
+		}
+	}()
+	for i := 0; i < 20; i++ {
+		mine(t, bc, "bob")
+	}
+	<-done
+}
+
+func TestReceivedChainWithMissingBlocks(t *testing.T) {
+	genesis, err := json.Marshal(params.Genesis)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, payload := range []string{
+		`{"Chain":[null]}`,
+		`{"Chain":[` + string(genesis) + `,null]}`,
+		`{"Chain":[]}`,
+	} {
+		other := &blockchain.Blockchain{}
+		if err := json.Unmarshal([]byte(payload), other); err != nil {
+			t.Fatal(err)
+		}
+		if other.IsValid(params) {
+			t.Errorf("chain %s is valid", payload)
+		}
+		bc := newChain()
+		if _, _, err := bc.ReplaceChain(other); !errors.Is(err, blockchain.ErrInvalidBlock) {
+			t.Errorf("replacing with chain %s returned %v, expected ErrInvalidBlock", payload, err)
+		}
+		if bc.GetLastIndex() != 0 {
+			t.Errorf("chain %s moved the tip", payload)
+		}
+	}
+}
+
+func TestCheckLocks(t *testing.T) {
+	parent := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		name  string
+		tx    blockchain.Transaction
+		valid bool
+	}{
+		{"unlocked", blockchain.Transaction{}, true},
+		{"height reached", blockchain.Transaction{LockHeight: 5}, true},
+		{"height ahead", blockchain.Transaction{LockHeight: 6}, false},
+		{"time reached", blockchain.Transaction{LockTime: parent}, true},
+		{"time ahead", blockchain.Transaction{LockTime: parent.Add(time.Second)}, false},
+		{"fraction of a second", blockchain.Transaction{LockTime: parent.Add(-time.Millisecond)}, false},
+		{"last block", blockchain.Transaction{ExpiryHeight: 5}, true},
+		{"expired", blockchain.Transaction{ExpiryHeight: 4}, false},
+	} {
+		if err := c.tx.CheckLocks(5, parent); (err == nil) != c.valid {
+			t.Errorf("%s : CheckLocks returned %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+// Engine is what the blockchain needs from the consensus engine of a network to validate headers and to pick
+// the branch with the most work. Engines live in the consensus package, which registers them under the names
+// ChainParams.Consensus refers to, so it must be imported by programs validating blocks.
+type Engine interface {
+	// VerifyHeader checks the consensus fields of the header of b, which don't depend on its parent.
+	VerifyHeader(b *Block, params *ChainParams) error
+	// Work returns what b adds to the cumulative work of its branch.
+	Work(b *Block) *big.Int
+}
+
+// ProofOfWork is the name of the engine requiring block hashes to start with Difficulty zeros.
+const ProofOfWork = "pow"
+
+var (
+	enginesMutex sync.RWMutex
+	engines      = make(map[string]Engine)
+)
+
+// RegisterEngine makes e the engine of the networks whose Consensus is name.
+func RegisterEngine(name string, e Engine) {
+	enginesMutex.Lock()
+	defer enginesMutex.Unlock()
+	engines[name] = e
+}
+
+// Engine returns the engine registered under params.Consensus. Without one, every header is invalid.
+func (params *ChainParams) Engine() Engine {
+	enginesMutex.RLock()
+	defer enginesMutex.RUnlock()
+	if e, exists := engines[params.Consensus]; exists {
+		return e
+	}
+	return unknownEngine(params.Consensus)
+}
+
+type unknownEngine string
+
+func (e unknownEngine) VerifyHeader(b *Block, params *ChainParams) error {
+	return fmt.Errorf("%w : consensus engine %q not registered", ErrInvalidBlock, string(e))
+}
+
+func (e unknownEngine) Work(b *Block) *big.Int {
+	return new(big.Int)
+}
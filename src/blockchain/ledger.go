@@ -0,0 +1,82 @@
+package blockchain
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Ledger records who owns the coins at the main chain tip. The account ledger is State and the UTXO ledger is
+// UTXOSet ; ChainParams.Ledger selects which one a network uses.
+type Ledger interface {
+	// ApplyBlock executes b on top of the ledger and returns the undo data reverting it. matured is the coinbase
+	// becoming spendable in b, if any. The ledger is left untouched if b can't be executed.
+	ApplyBlock(b *Block, params *ChainParams, matured *Transaction) (Undo, error)
+	// RevertBlock undoes the last block applied, given its undo data.
+	RevertBlock(undo Undo)
+	// FilterTxns returns, in order, the transactions of txns that can be executed one after the other
+	// in the block at index.
+	FilterTxns(txns []Transaction, index uint64) []Transaction
+	// Account returns what addr can spend in the next block.
+	Account(addr string) Account
+	// Root commits to the content of the ledger.
+	Root() string
+	// Supply sums every coin of the ledger, immature ones included.
+	Supply() uint64
+}
+
+// LedgerModel selects the Ledger of a network.
+type LedgerModel int
+
+const (
+	AccountModel LedgerModel = iota // balances and nonces by address
+	UTXOModel                       // unspent transaction outputs
+)
+
+// Undo holds what is needed to revert a block, depending on the ledger it was applied to.
+type Undo struct {
+	Accounts []AccountUndo `json:",omitempty"`
+	Outputs  []OutputUndo  `json:",omitempty"`
+}
+
+// newLedger returns the ledger as it is after the genesis block of params.
+func (params *ChainParams) newLedger() Ledger {
+	if params.Ledger == UTXOModel {
+		return params.genesisOutputs()
+	}
+	return params.genesisAccounts()
+}
+
+// decodeLedger decodes a ledger of the model of params.
+func (params *ChainParams) decodeLedger(data []byte) (Ledger, error) {
+	if len(data) == 0 {
+		return nil, errors.New("no ledger stored")
+	}
+	if params.Ledger == UTXOModel {
+		s := &UTXOSet{maturity: params.CoinbaseMaturity}
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, err
+		}
+		if s.Outputs == nil {
+			s.Outputs = make(map[string]*UTXO)
+		}
+		return s, nil
+	}
+	s := State{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// checkCoinbase checks that coinbase claims reward in the block at index.
+func checkCoinbase(coinbase *Transaction, index uint64, reward uint64) error {
+	claimed, err := coinbase.Amount()
+	if err != nil {
+		return err
+	}
+	if claimed != reward || coinbase.Fee != 0 || coinbase.Nonce != index || len(coinbase.Inputs) > 0 || coinbase.Witness != nil {
+		return fmt.Errorf("coinbase claims %d at index %d, expected %d at index %d", claimed, coinbase.Nonce, reward, index)
+	}
+	return nil
+}
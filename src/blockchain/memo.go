@@ -0,0 +1,60 @@
+package blockchain
+
+import (
+	"bytes"
+	"sort"
+)
+
+// MemoEntry is a main chain transaction carrying data.
+type MemoEntry struct {
+	BlockHash string
+	Index     uint64 // index of the block
+	Tx        Transaction
+}
+
+// memoIndex keeps the main chain transactions carrying data sorted by data, then hash,
+// so that the ones whose data share a prefix are next to each other.
+type memoIndex []MemoEntry
+
+func (m memoIndex) less(data []byte, hash string, i int) bool {
+	if c := bytes.Compare(data, m[i].Tx.Data); c != 0 {
+		return c < 0
+	}
+	return hash <= m[i].Tx.Hash
+}
+
+// add indexes the transactions of b carrying data.
+func (m *memoIndex) add(b *Block) {
+	for _, t := range b.Txns {
+		if len(t.Data) == 0 {
+			continue
+		}
+		i := sort.Search(len(*m), func(i int) bool { return m.less(t.Data, t.Hash, i) })
+		*m = append(*m, MemoEntry{})
+		copy((*m)[i+1:], (*m)[i:])
+		(*m)[i] = MemoEntry{BlockHash: b.Hash, Index: b.Index, Tx: t}
+	}
+}
+
+// remove drops the transactions of b from the index.
+func (m *memoIndex) remove(b *Block) {
+	for _, t := range b.Txns {
+		if len(t.Data) == 0 {
+			continue
+		}
+		i := sort.Search(len(*m), func(i int) bool { return m.less(t.Data, t.Hash, i) })
+		if i < len(*m) && (*m)[i].BlockHash == b.Hash && (*m)[i].Tx.Hash == t.Hash {
+			*m = append((*m)[:i], (*m)[i+1:]...)
+		}
+	}
+}
+
+// find returns at most limit transactions whose data starts with prefix, in data order.
+func (m memoIndex) find(prefix []byte, limit int) []MemoEntry {
+	entries := make([]MemoEntry, 0)
+	i := sort.Search(len(m), func(i int) bool { return bytes.Compare(prefix, m[i].Tx.Data) <= 0 })
+	for ; i < len(m) && len(entries) < limit && bytes.HasPrefix(m[i].Tx.Data, prefix); i++ {
+		entries = append(entries, m[i])
+	}
+	return entries
+}
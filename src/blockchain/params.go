@@ -0,0 +1,231 @@
+package blockchain
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// ChainParams defines a network : its genesis block, block spacing and rewards.
+// Nodes only talk to peers sharing their network magic and genesis block.
+//
+// The subsidy of the first block is InitialSubsidy and halves every HalvingInterval blocks, never going below
+// TailEmission. Once MaxSupply coins exist, including the genesis allocations, no more are created.
+type ChainParams struct {
+	Name            string
+	Magic           uint32
+	Genesis         *Block
+	GenesisHash     string
+	Allocations     map[string]uint64 // balances credited by the genesis block
+	Ledger          LedgerModel
+	Consensus       string        // name of the Engine validating blocks
+	BlockTime       time.Duration // targeted time between two blocks, which miners wait for since the tip by default
+	InitialSubsidy  uint64
+	HalvingInterval uint64 // 0 disables halving
+	TailEmission    uint64
+	MaxSupply       uint64 // 0 leaves the supply uncapped
+	// CoinbaseMaturity is the number of blocks after which a coinbase reward can be spent. At least 1.
+	CoinbaseMaturity uint64
+	Difficulty       int
+	MinTxFee         uint64 // lowest fee a transaction needs to enter the mempool, on top of its data fee
+	DataByteFee      uint64 // fee due for each byte of data of a transaction
+}
+
+var MainnetParams = newChainParams(&ChainParams{
+	Name:             "mainnet",
+	Magic:            0x6b657401,
+	GenesisHash:      "47dcad18347989bb4a89370e4740d619b82162e5301072d58e8eaee71f0a9833",
+	Allocations:      map[string]uint64{},
+	Consensus:        ProofOfWork,
+	BlockTime:        time.Minute,
+	InitialSubsidy:   32,
+	HalvingInterval:  210000,
+	TailEmission:     0,
+	MaxSupply:        13230000,
+	CoinbaseMaturity: 100,
+	Difficulty:       4,
+	MinTxFee:         1,
+	DataByteFee:      1,
+}, time.Date(2021, time.December, 20, 0, 0, 0, 0, time.UTC))
+
+var TestnetParams = newChainParams(&ChainParams{
+	Name:        "testnet",
+	Magic:       0x6b657402,
+	GenesisHash: "3f45df64d501d0abaa037e0be4966129b2bdd8123d048ac550845ff421004975",
+	Allocations: map[string]uint64{
+		// faucet
+		"a33e14a8aa2522b758823536c05c894199feeb3b49ca98650edf3fee2336fb2e": 1000000,
+	},
+	Consensus:        ProofOfWork,
+	BlockTime:        10 * time.Second,
+	InitialSubsidy:   32,
+	HalvingInterval:  10000,
+	TailEmission:     1,
+	MaxSupply:        0,
+	CoinbaseMaturity: 10,
+	Difficulty:       1,
+	MinTxFee:         1,
+	DataByteFee:      1,
+}, time.Date(2021, time.December, 21, 0, 0, 0, 0, time.UTC))
+
+// RegtestParams mines instantly, for local testing.
+var RegtestParams = newChainParams(&ChainParams{
+	Name:             "regtest",
+	Magic:            0x6b657403,
+	GenesisHash:      "5399ad037626848937ef4fc6d6e74596a841282f1ab013210edc56a890491a00",
+	Allocations:      map[string]uint64{},
+	Consensus:        ProofOfWork,
+	BlockTime:        time.Second,
+	InitialSubsidy:   32,
+	HalvingInterval:  150,
+	TailEmission:     0,
+	MaxSupply:        0,
+	CoinbaseMaturity: 1,
+	Difficulty:       0,
+	MinTxFee:         0,
+	DataByteFee:      0,
+}, time.Date(2021, time.December, 22, 0, 0, 0, 0, time.UTC))
+
+// UTXORegtestParams is RegtestParams on a UTXO ledger.
+var UTXORegtestParams = newChainParams(&ChainParams{
+	Name:             "regtest-utxo",
+	Magic:            0x6b657404,
+	GenesisHash:      "c67de5dc4fc83a14de756a5584a9d8ef75c400d731f0f8f4c21ef688677489d9",
+	Allocations:      map[string]uint64{},
+	Consensus:        ProofOfWork,
+	Ledger:           UTXOModel,
+	BlockTime:        time.Second,
+	InitialSubsidy:   32,
+	HalvingInterval:  150,
+	TailEmission:     0,
+	MaxSupply:        0,
+	CoinbaseMaturity: 1,
+	Difficulty:       0,
+	MinTxFee:         0,
+	DataByteFee:      0,
+}, time.Date(2021, time.December, 23, 0, 0, 0, 0, time.UTC))
+
+var networks = map[string]*ChainParams{
+	MainnetParams.Name:     MainnetParams,
+	TestnetParams.Name:     TestnetParams,
+	RegtestParams.Name:     RegtestParams,
+	UTXORegtestParams.Name: UTXORegtestParams,
+}
+
+// GetChainParams returns the parameters of the network called name.
+func GetChainParams(name string) (*ChainParams, error) {
+	if params, exists := networks[name]; exists {
+		return params, nil
+	}
+	return nil, fmt.Errorf("unknown network %s", name)
+}
+
+// newChainParams builds the genesis block of params and checks it against its expected hash.
+func newChainParams(params *ChainParams, timestamp time.Time) *ChainParams {
+	params.Genesis = &Block{
+		Index:     0,
+		PrevHash:  "",
+		Timestamp: timestamp,
+		Txns:      nil,
+		StateRoot: params.newLedger().Root(),
+	}
+	params.Genesis.Hash = params.Genesis.ComputeHash()
+	if params.Genesis.Hash != params.GenesisHash {
+		panic(fmt.Sprintf("%s genesis hash is %s, expected %s", params.Name, params.Genesis.Hash, params.GenesisHash))
+	}
+	return params
+}
+
+// genesisAccounts returns the accounts as they are after the genesis block.
+func (params *ChainParams) genesisAccounts() State {
+	accounts := make(State, len(params.Allocations))
+	for addr, balance := range params.Allocations {
+		accounts[addr] = &Account{
+			Address: addr,
+			Balance: balance,
+		}
+	}
+	return accounts
+}
+
+// Subsidy returns the coins created by the block at index and paid to its miner along with the fees.
+func (params *ChainParams) Subsidy(index uint64) uint64 {
+	subsidy := params.scheduledSubsidy(index)
+	if params.MaxSupply > 0 && index > 0 {
+		issued := params.Issued(index - 1)
+		if issued >= params.MaxSupply {
+			return 0
+		}
+		if params.MaxSupply-issued < subsidy {
+			subsidy = params.MaxSupply - issued
+		}
+	}
+	return subsidy
+}
+
+// Issued returns the coins created by the blocks up to and including index, genesis allocations included.
+func (params *ChainParams) Issued(index uint64) uint64 {
+	var issued uint64
+	for _, balance := range params.Allocations {
+		issued = saturatingAdd(issued, balance)
+	}
+
+	interval := params.HalvingInterval
+	if interval == 0 {
+		interval = index
+	}
+	// blocks of a halving era all have the same scheduled subsidy
+	for start := uint64(1); start <= index; start += interval {
+		end := start + interval - 1
+		if end > index || end < start {
+			end = index
+		}
+		subsidy := params.scheduledSubsidy(start)
+		if subsidy == 0 {
+			break
+		}
+		hi, era := bits.Mul64(subsidy, end-start+1)
+		if hi != 0 {
+			era = math.MaxUint64
+		}
+		issued = saturatingAdd(issued, era)
+		if params.MaxSupply > 0 && issued >= params.MaxSupply {
+			return params.MaxSupply
+		}
+	}
+	return issued
+}
+
+// DataFee returns the fee t owes for its data.
+func (params *ChainParams) DataFee(t *Transaction) uint64 {
+	return uint64(len(t.Data)) * params.DataByteFee
+}
+
+// scheduledSubsidy returns the subsidy of the block at index, ignoring MaxSupply.
+func (params *ChainParams) scheduledSubsidy(index uint64) uint64 {
+	if index == 0 {
+		return 0
+	}
+	subsidy := params.InitialSubsidy
+	if params.HalvingInterval > 0 {
+		halvings := (index - 1) / params.HalvingInterval
+		if halvings >= 64 {
+			subsidy = 0
+		} else {
+			subsidy >>= halvings
+		}
+	}
+	if subsidy < params.TailEmission {
+		subsidy = params.TailEmission
+	}
+	return subsidy
+}
+
+func saturatingAdd(a, b uint64) uint64 {
+	sum, err := add(a, b)
+	if err != nil {
+		return math.MaxUint64
+	}
+	return sum
+}
@@ -0,0 +1,312 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"ketcoin/src/crypto"
+	"strconv"
+	"strings"
+)
+
+// Scripts lock coins to a predicate instead of a public key. A script is a list of whitespace separated tokens
+// run on a stack of byte strings : 0x prefixed hex data and decimal numbers are pushed, OP_ tokens are operations.
+// Numbers are 8 bytes big-endian and a value is false when all its bytes are zero.
+//
+// Coins sent to ScriptAddress(script) can be spent by a transaction with that address as Sender and a Witness
+// revealing the script. The script is run on the witness arguments and must leave a true value on top of
+// the stack. Running a script is deterministic and bounded by MAX_SCRIPT_GAS.
+//
+// Operations :
+//
+//	OP_DUP OP_DROP OP_SWAP          stack manipulation
+//	OP_EQUAL OP_VERIFY OP_EQUALVERIFY
+//	OP_ADD OP_GREATERTHANOREQUAL    on numbers
+//	OP_SHA256                       hashes the top value
+//	OP_CHECKSIG                     pops a public key and a signature, pushes whether the signature is a valid
+//	                                MSS signature of the transaction hash by the key
+//	OP_CHECKLOCKHEIGHT              fails unless the transaction LockHeight is at least the top number
+//	OP_CHECKLOCKTIME                fails unless the transaction LockTime is at least the top number, as unix time
+//	OP_IF OP_ELSE OP_ENDIF          runs a branch depending on the popped value
+const (
+	MAX_SCRIPT_SIZE = 10000
+	MAX_SCRIPT_GAS  = 10000
+	MAX_STACK_SIZE  = 100
+	MAX_VALUE_SIZE  = crypto.SignatureSize
+)
+
+var (
+	ErrScriptFailed = errors.New("script failed")
+	ErrOutOfGas     = errors.New("script ran out of gas")
+)
+
+// Witness unlocks the coins held by a script address.
+type Witness struct {
+	Script string
+	Args   [][]byte // initial stack, the last argument on top
+}
+
+// ScriptAddress returns the address holding coins locked to script.
+func ScriptAddress(script string) string {
+	h := sha256.Sum256([]byte(script))
+	return hex.EncodeToString(h[:])
+}
+
+// gasCost returns the gas an operation uses. Data pushes and control flow cost 1.
+func gasCost(op string, stack [][]byte) uint64 {
+	switch op {
+	case "OP_SHA256":
+		if len(stack) > 0 {
+			return 10 + uint64(len(stack[len(stack)-1]))/64
+		}
+		return 10
+	case "OP_CHECKSIG":
+		return 1000
+	}
+	return 1
+}
+
+// VerifyWitness checks that the Transaction's witness script hashes to its sender and runs successfully.
+func (t *Transaction) VerifyWitness() error {
+	w := t.Witness
+	if w == nil {
+		return errors.New("no witness")
+	}
+	if len(w.Script) > MAX_SCRIPT_SIZE || len(w.Args) > MAX_STACK_SIZE {
+		return fmt.Errorf("%w : witness too large", ErrScriptFailed)
+	}
+	if ScriptAddress(w.Script) != t.Sender {
+		return fmt.Errorf("%w : script does not hash to sender", ErrScriptFailed)
+	}
+	for _, arg := range w.Args {
+		if len(arg) > MAX_VALUE_SIZE {
+			return fmt.Errorf("%w : argument too large", ErrScriptFailed)
+		}
+	}
+	return RunScript(w.Script, w.Args, t)
+}
+
+// RunScript runs script for t on a stack holding args.
+func RunScript(script string, args [][]byte, t *Transaction) error {
+	stack := make([][]byte, len(args))
+	copy(stack, args)
+	var gas uint64
+	var branches []bool // whether each enclosing OP_IF branch runs
+
+	pop := func() ([]byte, error) {
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("%w : stack empty", ErrScriptFailed)
+		}
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return v, nil
+	}
+	popNumber := func() (int64, error) {
+		v, err := pop()
+		if err != nil {
+			return 0, err
+		}
+		return decodeNumber(v)
+	}
+
+	for _, op := range strings.Fields(script) {
+		if gas += gasCost(op, stack); gas > MAX_SCRIPT_GAS {
+			return ErrOutOfGas
+		}
+		running := true
+		for _, b := range branches {
+			running = running && b
+		}
+
+		switch op {
+		case "OP_IF":
+			taken := false
+			if running {
+				v, err := pop()
+				if err != nil {
+					return err
+				}
+				taken = isTrue(v)
+			}
+			branches = append(branches, taken)
+			continue
+		case "OP_ELSE":
+			if len(branches) == 0 {
+				return fmt.Errorf("%w : OP_ELSE without OP_IF", ErrScriptFailed)
+			}
+			branches[len(branches)-1] = !branches[len(branches)-1]
+			continue
+		case "OP_ENDIF":
+			if len(branches) == 0 {
+				return fmt.Errorf("%w : OP_ENDIF without OP_IF", ErrScriptFailed)
+			}
+			branches = branches[:len(branches)-1]
+			continue
+		}
+		if !running {
+			continue
+		}
+
+		var err error
+		switch op {
+		case "OP_DUP":
+			var v []byte
+			if v, err = pop(); err == nil {
+				stack = append(stack, v, v)
+			}
+		case "OP_DROP":
+			_, err = pop()
+		case "OP_SWAP":
+			var a, b []byte
+			if b, err = pop(); err == nil {
+				if a, err = pop(); err == nil {
+					stack = append(stack, b, a)
+				}
+			}
+		case "OP_EQUAL", "OP_EQUALVERIFY":
+			var a, b []byte
+			if b, err = pop(); err == nil {
+				if a, err = pop(); err == nil {
+					stack = append(stack, encodeBool(bytes.Equal(a, b)))
+				}
+			}
+			if err == nil && op == "OP_EQUALVERIFY" {
+				err = verify(pop())
+			}
+		case "OP_VERIFY":
+			err = verify(pop())
+		case "OP_ADD", "OP_GREATERTHANOREQUAL":
+			var a, b int64
+			if b, err = popNumber(); err == nil {
+				if a, err = popNumber(); err == nil {
+					if op == "OP_ADD" {
+						var sum int64
+						sum, err = addNumbers(a, b)
+						stack = append(stack, encodeNumber(sum))
+					} else {
+						stack = append(stack, encodeBool(a >= b))
+					}
+				}
+			}
+		case "OP_SHA256":
+			var v []byte
+			if v, err = pop(); err == nil {
+				h := sha256.Sum256(v)
+				stack = append(stack, h[:])
+			}
+		case "OP_CHECKSIG":
+			var key, sig []byte
+			if key, err = pop(); err == nil {
+				if sig, err = pop(); err == nil {
+					stack = append(stack, encodeBool(checkSig(t, key, sig)))
+				}
+			}
+		case "OP_CHECKLOCKHEIGHT", "OP_CHECKLOCKTIME":
+			var lock int64
+			if lock, err = popNumber(); err == nil {
+				stack = append(stack, encodeNumber(lock))
+				if op == "OP_CHECKLOCKHEIGHT" && (lock < 0 || t.LockHeight < uint64(lock)) {
+					err = fmt.Errorf("%w : lock height below %d", ErrScriptFailed, lock)
+				}
+				if op == "OP_CHECKLOCKTIME" && (t.LockTime.IsZero() || t.LockTime.Unix() < lock) {
+					err = fmt.Errorf("%w : lock time before %d", ErrScriptFailed, lock)
+				}
+			}
+		default:
+			var v []byte
+			if v, err = parseValue(op); err == nil {
+				stack = append(stack, v)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		if len(stack) > MAX_STACK_SIZE {
+			return fmt.Errorf("%w : stack overflow", ErrScriptFailed)
+		}
+	}
+
+	if len(branches) != 0 {
+		return fmt.Errorf("%w : OP_IF without OP_ENDIF", ErrScriptFailed)
+	}
+	if len(stack) == 0 || !isTrue(stack[len(stack)-1]) {
+		return fmt.Errorf("%w : false result", ErrScriptFailed)
+	}
+	return nil
+}
+
+// parseValue decodes a data push.
+func parseValue(token string) ([]byte, error) {
+	if strings.HasPrefix(token, "0x") {
+		v, err := hex.DecodeString(token[2:])
+		if err != nil || len(v) > MAX_VALUE_SIZE {
+			return nil, fmt.Errorf("%w : bad data %s", ErrScriptFailed, token)
+		}
+		return v, nil
+	}
+	number, err := strconv.ParseInt(token, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w : unknown operation %s", ErrScriptFailed, token)
+	}
+	return encodeNumber(number), nil
+}
+
+// checkSig reports whether sig is a signature of the hash of t by key.
+func checkSig(t *Transaction, key []byte, sig []byte) bool {
+	hash, err := hex.DecodeString(t.Hash)
+	if err != nil || len(key) != 32 || len(hash) != 32 || t.Hash != t.ComputeHash() {
+		return false
+	}
+	signature, err := crypto.DecodeSignature(sig)
+	if err != nil {
+		return false
+	}
+	return crypto.Verify(signature, *(*[32]byte)(key), *(*[32]byte)(hash))
+}
+
+func verify(v []byte, err error) error {
+	if err == nil && !isTrue(v) {
+		err = fmt.Errorf("%w : verify failed", ErrScriptFailed)
+	}
+	return err
+}
+
+func isTrue(v []byte) bool {
+	for _, b := range v {
+		if b != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func encodeBool(b bool) []byte {
+	if b {
+		return encodeNumber(1)
+	}
+	return encodeNumber(0)
+}
+
+func encodeNumber(number int64) []byte {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(number))
+	return v
+}
+
+func decodeNumber(v []byte) (int64, error) {
+	if len(v) != 8 {
+		return 0, fmt.Errorf("%w : %d bytes value is not a number", ErrScriptFailed, len(v))
+	}
+	return int64(binary.BigEndian.Uint64(v)), nil
+}
+
+func addNumbers(a, b int64) (int64, error) {
+	sum := a + b
+	if (a > 0 && b > 0 && sum < 0) || (a < 0 && b < 0 && sum >= 0) {
+		return 0, fmt.Errorf("%w : %v", ErrScriptFailed, ErrOverflow)
+	}
+	return sum, nil
+}
@@ -0,0 +1,127 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunScript(t *testing.T) {
+	secret := []byte("swap secret")
+	h := sha256.Sum256(secret)
+	hashlock := fmt.Sprintf("OP_SHA256 0x%x OP_EQUAL", h)
+	lockTime := time.Date(2022, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, c := range []struct {
+		name   string
+		script string
+		args   [][]byte
+		tx     Transaction
+		err    error // nil when the script succeeds
+	}{
+		{"hashlock", hashlock, [][]byte{secret}, Transaction{}, nil},
+		{"hashlock wrong secret", hashlock, [][]byte{[]byte("wrong")}, Transaction{}, ErrScriptFailed},
+		{"hashlock no secret", hashlock, nil, Transaction{}, ErrScriptFailed},
+
+		{"lock height reached", "100 OP_CHECKLOCKHEIGHT", nil, Transaction{LockHeight: 100}, nil},
+		{"lock height below", "100 OP_CHECKLOCKHEIGHT", nil, Transaction{LockHeight: 99}, ErrScriptFailed},
+		{"negative lock height", "-1 OP_CHECKLOCKHEIGHT", nil, Transaction{LockHeight: 100}, ErrScriptFailed},
+		{"lock time reached", fmt.Sprintf("%d OP_CHECKLOCKTIME", lockTime.Unix()), nil, Transaction{LockTime: lockTime}, nil},
+		{"lock time before", fmt.Sprintf("%d OP_CHECKLOCKTIME", lockTime.Unix()), nil, Transaction{LockTime: lockTime.Add(-time.Second)}, ErrScriptFailed},
+		{"no lock time", "0 OP_CHECKLOCKTIME", nil, Transaction{}, ErrScriptFailed},
+
+		{"gas exhausted", strings.Repeat("0x00 0x00 OP_CHECKSIG OP_DROP ", 10) + "1", nil, Transaction{}, ErrOutOfGas},
+		{"gas exhausted in skipped branch", "0 OP_IF " + strings.Repeat("OP_DUP ", MAX_SCRIPT_GAS) + "OP_ENDIF 1", nil, Transaction{}, ErrOutOfGas},
+		{"stack full", strings.Repeat("1 ", MAX_STACK_SIZE), nil, Transaction{}, nil},
+		{"stack overflow", strings.Repeat("1 ", MAX_STACK_SIZE+1), nil, Transaction{}, ErrScriptFailed},
+		{"stack empty", "OP_DROP 1", nil, Transaction{}, ErrScriptFailed},
+		{"false result", "0", nil, Transaction{}, ErrScriptFailed},
+		{"empty script", "", nil, Transaction{}, ErrScriptFailed},
+		{"unknown operation", "OP_NOP 1", nil, Transaction{}, ErrScriptFailed},
+
+		{"if taken", "1 OP_IF 1 OP_ELSE 0 OP_ENDIF", nil, Transaction{}, nil},
+		{"else taken", "0 OP_IF 0 OP_ELSE 1 OP_ENDIF", nil, Transaction{}, nil},
+		{"nested in skipped branch", "0 OP_IF 1 OP_IF 0 OP_ELSE 0 OP_ENDIF OP_ELSE 1 OP_ENDIF", nil, Transaction{}, nil},
+		{"if without endif", "1 OP_IF 1", nil, Transaction{}, ErrScriptFailed},
+		{"else without if", "1 OP_ELSE 1", nil, Transaction{}, ErrScriptFailed},
+		{"endif without if", "1 OP_ENDIF", nil, Transaction{}, ErrScriptFailed},
+		{"if on empty stack", "OP_IF 1 OP_ENDIF", nil, Transaction{}, ErrScriptFailed},
+
+		{"add", "2 3 OP_ADD 5 OP_EQUAL", nil, Transaction{}, nil},
+		{"add 8 byte data", "0x0000000000000002 3 OP_ADD 5 OP_EQUAL", nil, Transaction{}, nil},
+		{"add short data", "0x02 3 OP_ADD", nil, Transaction{}, ErrScriptFailed},
+		{"add long data", "0x000000000000000002 3 OP_ADD", nil, Transaction{}, ErrScriptFailed},
+		{"add overflow", "9223372036854775807 1 OP_ADD", nil, Transaction{}, ErrScriptFailed},
+		{"compare short data", "0x01 1 OP_GREATERTHANOREQUAL", nil, Transaction{}, ErrScriptFailed},
+		{"lock height short data", "0x64 OP_CHECKLOCKHEIGHT", nil, Transaction{LockHeight: 100}, ErrScriptFailed},
+	} {
+		err := RunScript(c.script, c.args, &c.tx)
+		if (err == nil) != (c.err == nil) || (c.err != nil && !errors.Is(err, c.err)) {
+			t.Errorf("%s : RunScript returned %v, expected %v", c.name, err, c.err)
+		}
+	}
+}
+
+// multisigFixture holds a transaction signed by three MSS keys, which take minutes to generate.
+type multisigFixture struct {
+	Keys        [][]byte
+	Signatures  [][]byte // of the transaction, by each key
+	Transaction Transaction
+}
+
+// multisigScript is satisfied by signatures of any two of the keys, the signature of the first key on top.
+func multisigScript(keys [][]byte) string {
+	return fmt.Sprintf("0x%x OP_CHECKSIG OP_SWAP 0x%x OP_CHECKSIG OP_ADD OP_SWAP 0x%x OP_CHECKSIG OP_ADD 2 OP_GREATERTHANOREQUAL",
+		keys[0], keys[1], keys[2])
+}
+
+func TestMultisigScript(t *testing.T) {
+	data, err := os.ReadFile("testdata/multisig.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f multisigFixture
+	if err := json.Unmarshal(data, &f); err != nil {
+		t.Fatal(err)
+	}
+	script := multisigScript(f.Keys)
+	if f.Transaction.Sender != ScriptAddress(script) || f.Transaction.Hash != f.Transaction.ComputeHash() {
+		t.Fatal("fixture doesn't match the script or the transaction hash")
+	}
+	a, b, c, none := f.Signatures[0], f.Signatures[1], f.Signatures[2], []byte{}
+
+	for _, s := range []struct {
+		name  string
+		args  [][]byte // the last argument is checked against the first key
+		valid bool
+	}{
+		{"first and second", [][]byte{none, b, a}, true},
+		{"first and third", [][]byte{c, none, a}, true},
+		{"second and third", [][]byte{c, b, none}, true},
+		{"all", [][]byte{c, b, a}, true},
+		{"first only", [][]byte{none, none, a}, false},
+		{"first twice", [][]byte{none, a, a}, false},
+		{"swapped", [][]byte{none, a, b}, false},
+		{"missing argument", [][]byte{b, a}, false},
+	} {
+		tx := f.Transaction
+		tx.Witness = &Witness{Script: script, Args: s.args}
+		if err := tx.VerifyWitness(); (err == nil) != s.valid {
+			t.Errorf("%s : VerifyWitness returned %v", s.name, err)
+		}
+	}
+
+	// the signatures only cover this transaction
+	tx := f.Transaction
+	tx.Fee++
+	tx.Hash = tx.ComputeHash()
+	tx.Witness = &Witness{Script: script, Args: [][]byte{c, b, a}}
+	if err := tx.VerifyWitness(); !errors.Is(err, ErrScriptFailed) {
+		t.Errorf("modified transaction : VerifyWitness returned %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+)
+
+var ErrOverflow = errors.New("amount overflows 64 bits")
+
+// State maps addresses to the accounts of the ledger.
+type State map[string]*Account
+
+// ApplyTransaction executes tx on state : the sender pays the outputs and the fee, and each output is credited
+// to its address. state is left untouched if tx can't be executed.
+func ApplyTransaction(state State, tx *Transaction) error {
+	return applyTransaction(newUndoLog(state), tx)
+}
+
+func applyTransaction(u *undoLog, t *Transaction) error {
+	if len(t.Inputs) > 0 {
+		return fmt.Errorf("transaction %s has inputs, which accounts don't use", t.Hash)
+	}
+	cost, err := t.Cost()
+	if err != nil {
+		return err
+	}
+	acc, exists := u.accounts[t.Sender]
+	if !exists || acc.Balance < cost {
+		return fmt.Errorf("insufficient balance for transaction %s", t.Hash)
+	}
+	if t.Nonce != acc.Nonce {
+		return fmt.Errorf("transaction %s has nonce %d, expected %d", t.Hash, t.Nonce, acc.Nonce)
+	}
+	// new balances are computed first so that nothing is modified if an output overflows
+	balances := map[string]uint64{t.Sender: acc.Balance - cost}
+	for _, o := range t.Outputs {
+		balance, seen := balances[o.Address]
+		if acc, exists := u.accounts[o.Address]; !seen && exists {
+			balance = acc.Balance
+		}
+		if balances[o.Address], err = add(balance, o.Amount); err != nil {
+			return err
+		}
+	}
+
+	sender := u.touch(t.Sender)
+	sender.Balance = balances[t.Sender]
+	sender.Nonce++
+	for _, o := range t.Outputs {
+		u.touch(o.Address).Balance = balances[o.Address]
+	}
+	return nil
+}
+
+// ApplyBlock executes b on the accounts. See applyBlock.
+func (s State) ApplyBlock(b *Block, params *ChainParams, matured *Transaction) (Undo, error) {
+	records, err := applyBlock(s, b, params, matured)
+	return Undo{Accounts: records}, err
+}
+
+func (s State) RevertBlock(undo Undo) {
+	revertBlock(s, undo.Accounts)
+}
+
+func (s State) FilterTxns(txns []Transaction, index uint64) []Transaction {
+	u := newUndoLog(s)
+	defer func() { revertBlock(s, u.records) }()
+
+	valid := make([]Transaction, 0, len(txns))
+	for i := range txns {
+		if applyTransaction(u, &txns[i]) == nil {
+			valid = append(valid, txns[i])
+		}
+	}
+	return valid
+}
+
+// Account returns a copy of the account at addr, which is empty if the address never received coins.
+func (s State) Account(addr string) Account {
+	if acc, exists := s[addr]; exists {
+		return *acc
+	}
+	return Account{Address: addr}
+}
+
+// applyBlock matures the coinbase reward that becomes spendable in b, if any, then executes the transactions
+// of b. The coinbase of b must claim the subsidy and the fees; it is credited as immature coins.
+// It returns the undo records reverting it. Nothing is modified if a transaction can't be executed.
+func applyBlock(state State, b *Block, params *ChainParams, matured *Transaction) ([]AccountUndo, error) {
+	u := newUndoLog(state)
+	undo, err := func() ([]AccountUndo, error) {
+		coinbase := b.Coinbase()
+		if coinbase == nil {
+			return nil, errors.New("first transaction is not a coinbase")
+		}
+		if matured != nil {
+			if err := matureCoinbase(u, matured); err != nil {
+				return nil, err
+			}
+		}
+
+		reward := params.Subsidy(b.Index)
+		for i := 1; i < len(b.Txns); i++ {
+			if b.Txns[i].IsCoinbase() {
+				return nil, fmt.Errorf("transaction %s is a second coinbase", b.Txns[i].Hash)
+			}
+			err := applyTransaction(u, &b.Txns[i])
+			if err == nil {
+				reward, err = add(reward, b.Txns[i].Fee)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if err := checkCoinbase(coinbase, b.Index, reward); err != nil {
+			return nil, err
+		}
+		for _, o := range coinbase.Outputs {
+			miner := u.touch(o.Address)
+			immature, err := add(miner.Immature, o.Amount)
+			if err != nil {
+				return nil, err
+			}
+			miner.Immature = immature
+		}
+		return u.records, nil
+	}()
+
+	if err != nil {
+		revertBlock(state, u.records)
+		return nil, fmt.Errorf("%w : %v", ErrInvalidBlock, err)
+	}
+	return undo, nil
+}
+
+// matureCoinbase makes the rewards paid by coinbase spendable.
+func matureCoinbase(u *undoLog, coinbase *Transaction) error {
+	for _, o := range coinbase.Outputs {
+		acc, exists := u.accounts[o.Address]
+		if !exists || acc.Immature < o.Amount {
+			return fmt.Errorf("coinbase %s is not immature anymore", coinbase.Hash)
+		}
+		balance, err := add(acc.Balance, o.Amount)
+		if err != nil {
+			return err
+		}
+		acc = u.touch(o.Address)
+		acc.Immature -= o.Amount
+		acc.Balance = balance
+	}
+	return nil
+}
+
+// Root hashes the accounts in address order so that every node computes the same root.
+func (s State) Root() string {
+	addrs := make([]string, 0, len(s))
+	for addr := range s {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	// fixed width numbers and length prefixed addresses, like Transaction.ComputeHash, so that no two states
+	// encode the same
+	var buf bytes.Buffer
+	writeUint := func(v uint64) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	writeUint(uint64(len(addrs)))
+	for _, addr := range addrs {
+		acc := s[addr]
+		writeUint(uint64(len(addr)))
+		buf.WriteString(addr)
+		writeUint(acc.Balance)
+		writeUint(acc.Immature)
+		writeUint(acc.Nonce)
+	}
+	h := sha256.Sum256(buf.Bytes())
+
+	return hex.EncodeToString(h[:])
+}
+
+// Supply sums the balances of every account, immature coins included.
+func (s State) Supply() uint64 {
+	var supply uint64
+	for _, acc := range s {
+		supply += acc.Balance + acc.Immature
+	}
+	return supply
+}
+
+// Amount sums the outputs of the Transaction.
+func (t *Transaction) Amount() (uint64, error) {
+	var amount uint64
+	for _, o := range t.Outputs {
+		var err error
+		if amount, err = add(amount, o.Amount); err != nil {
+			return 0, err
+		}
+	}
+	return amount, nil
+}
+
+// Cost returns what the Transaction takes from its sender's balance.
+func (t *Transaction) Cost() (uint64, error) {
+	amount, err := t.Amount()
+	if err != nil {
+		return 0, err
+	}
+	return add(amount, t.Fee)
+}
+
+func add(a, b uint64) (uint64, error) {
+	sum, carry := bits.Add64(a, b, 0)
+	if carry != 0 {
+		return 0, ErrOverflow
+	}
+	return sum, nil
+}
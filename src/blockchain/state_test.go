@@ -0,0 +1,172 @@
+package blockchain
+
+import (
+	"math"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+var testAddrs = []string{"alice", "bob", "carol", "dave"}
+
+// randomState returns accounts with balances ranging from nothing to close to the 64 bit limit.
+func randomState(r *rand.Rand) State {
+	s := State{}
+	for _, addr := range testAddrs[:3] {
+		s[addr] = &Account{Address: addr, Balance: randomAmount(r), Immature: randomAmount(r), Nonce: uint64(r.Intn(3))}
+	}
+	return s
+}
+
+// randomAmount is small, large or close enough to the 64 bit limit to overflow once added to something.
+func randomAmount(r *rand.Rand) uint64 {
+	switch r.Intn(4) {
+	case 0:
+		return uint64(r.Intn(100))
+	case 1:
+		return uint64(r.Int63())
+	case 2:
+		return math.MaxUint64 - uint64(r.Intn(100))
+	}
+	return uint64(r.Intn(1000))
+}
+
+// randomTransaction sends from an account of s, most of the time with its expected nonce and without
+// spending more than its balance.
+func randomTransaction(r *rand.Rand, s State) Transaction {
+	sender := testAddrs[r.Intn(len(testAddrs))]
+	tx := Transaction{Sender: sender, Fee: uint64(r.Intn(10))}
+	if acc, exists := s[sender]; exists {
+		tx.Nonce = acc.Nonce
+		if r.Intn(4) == 0 {
+			tx.Nonce++
+		}
+	}
+	for i := r.Intn(3) + 1; i > 0; i-- {
+		o := Output{Address: testAddrs[r.Intn(len(testAddrs))], Amount: randomAmount(r)}
+		if acc, exists := s[sender]; exists && r.Intn(2) == 0 && acc.Balance > 0 {
+			o.Amount = uint64(r.Int63n(int64(acc.Balance%math.MaxInt64) + 1))
+		}
+		tx.Outputs = append(tx.Outputs, o)
+	}
+	if r.Intn(8) == 0 {
+		tx.Fee = math.MaxUint64
+	}
+	tx.Hash = tx.ComputeHash()
+	return tx
+}
+
+// supply sums the coins of s without overflowing.
+func supply(s State) *big.Int {
+	total := new(big.Int)
+	for _, acc := range s {
+		total.Add(total, new(big.Int).SetUint64(acc.Balance))
+		total.Add(total, new(big.Int).SetUint64(acc.Immature))
+	}
+	return total
+}
+
+func copyState(s State) State {
+	c := make(State, len(s))
+	for addr, acc := range s {
+		copied := *acc
+		c[addr] = &copied
+	}
+	return c
+}
+
+func TestApplyTransactionConservesSupply(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	applied, failed := 0, 0
+	for i := 0; i < 2000; i++ {
+		s := randomState(r)
+		for j := 0; j < 10; j++ {
+			tx := randomTransaction(r, s)
+			before, prev := supply(s), copyState(s)
+			if err := ApplyTransaction(s, &tx); err != nil {
+				failed++
+				if !reflect.DeepEqual(s, prev) {
+					t.Fatalf("failed transaction %+v modified the state : %v", tx, err)
+				}
+				continue
+			}
+			applied++
+			// the fee leaves the accounts until a coinbase pays it back
+			expected := new(big.Int).Sub(before, new(big.Int).SetUint64(tx.Fee))
+			if supply(s).Cmp(expected) != 0 {
+				t.Fatalf("transaction %+v changed the supply from %s to %s, expected %s", tx, before, supply(s), expected)
+			}
+			if s[tx.Sender].Nonce != prev[tx.Sender].Nonce+1 {
+				t.Fatalf("transaction %+v didn't increment the sender nonce", tx)
+			}
+		}
+	}
+	if applied == 0 || failed == 0 {
+		t.Fatalf("expected both applied and failed transactions, got %d and %d", applied, failed)
+	}
+}
+
+func TestApplyBlockConservesSupply(t *testing.T) {
+	params := RegtestParams
+	r := rand.New(rand.NewSource(2))
+	applied, failed := 0, 0
+	for i := 0; i < 1000; i++ {
+		s := randomState(r)
+		index := uint64(r.Intn(1000) + 1)
+		var txns []Transaction
+		for j := r.Intn(5); j > 0; j-- {
+			txns = append(txns, randomTransaction(r, s))
+		}
+		if r.Intn(2) == 0 {
+			txns = s.FilterTxns(txns, index)
+		}
+		reward := params.Subsidy(index)
+		for _, tx := range txns {
+			reward += tx.Fee
+		}
+		if r.Intn(10) == 0 {
+			reward++
+		}
+		b := &Block{Index: index, Txns: append([]Transaction{NewCoinbase(index, testAddrs[r.Intn(len(testAddrs))], reward)}, txns...)}
+
+		before, prev := supply(s), copyState(s)
+		undo, err := applyBlock(s, b, params, nil)
+		if err != nil {
+			failed++
+			if !reflect.DeepEqual(s, prev) {
+				t.Fatalf("failed block modified the state : %v", err)
+			}
+			continue
+		}
+		applied++
+		expected := new(big.Int).Add(before, new(big.Int).SetUint64(params.Subsidy(index)))
+		if supply(s).Cmp(expected) != 0 {
+			t.Fatalf("block %d changed the supply from %s to %s, expected %s", index, before, supply(s), expected)
+		}
+		revertBlock(s, undo)
+		if !reflect.DeepEqual(s, prev) {
+			t.Fatalf("reverting block %d didn't restore the state", index)
+		}
+	}
+	if applied == 0 || failed == 0 {
+		t.Fatalf("expected both applied and failed blocks, got %d and %d", applied, failed)
+	}
+}
+
+func TestStateRootIsUnambiguous(t *testing.T) {
+	// the address and the balance used to run together
+	a := State{"a1": &Account{Address: "a1", Balance: 23}}
+	b := State{"a": &Account{Address: "a", Balance: 123}}
+	if a.Root() == b.Root() {
+		t.Errorf("different states share the root %s", a.Root())
+	}
+}
+
+func TestUTXORootIsUnambiguous(t *testing.T) {
+	a := &UTXOSet{Outputs: map[string]*UTXO{"tx:0": {Output: Output{Address: "b1", Amount: 2}}}}
+	b := &UTXOSet{Outputs: map[string]*UTXO{"tx:0": {Output: Output{Address: "b", Amount: 12}}}}
+	if a.Root() == b.Root() {
+		t.Errorf("different sets share the root %s", a.Root())
+	}
+}
@@ -0,0 +1,343 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	blocksFile = "blocks.dat"
+	indexFile  = "index.dat"
+	undoFile   = "undo.dat"
+	stateFile  = "state.json"
+)
+
+// recordHeaderSize is the size of the length and checksum preceding each record of the block and index files.
+const recordHeaderSize = 8
+
+// Store persists blocks, their undo data, the header index and the ledger to a data directory.
+// Blocks, undo data and index entries are appended to their files as length-prefixed, checksummed JSON records,
+// so that a record torn by a crash is detected and dropped on the next start. The ledger is a snapshot of a main
+// chain block, replaced atomically from time to time.
+type Store struct {
+	dir     string
+	blocks  *os.File
+	index   *os.File
+	undo    *os.File
+	entries []indexEntry
+	known   map[string]bool // hashes of the stored blocks
+}
+
+// indexEntry locates a block in the block file.
+type indexEntry struct {
+	Hash     string
+	PrevHash string
+	Index    uint64
+	Offset   int64
+}
+
+// stateSnapshot is the ledger at Tip.
+type stateSnapshot struct {
+	Tip    string
+	Ledger json.RawMessage
+}
+
+// undoRecord is the undo data of a block, written every time the block is connected.
+type undoRecord struct {
+	Hash string
+	Undo Undo
+}
+
+// OpenStore opens the store held in dir, creating it if needed and recovering from an interrupted write.
+func OpenStore(dir string) (*Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := os.OpenFile(filepath.Join(dir, blocksFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(filepath.Join(dir, indexFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		blocks.Close()
+		return nil, err
+	}
+	undo, err := os.OpenFile(filepath.Join(dir, undoFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		blocks.Close()
+		index.Close()
+		return nil, err
+	}
+
+	s := &Store{
+		dir:    dir,
+		blocks: blocks,
+		index:  index,
+		undo:   undo,
+	}
+	if err := s.recover(); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// recover truncates the block and undo files after their last intact record and brings the index in line
+// with the block file.
+func (s *Store) recover() error {
+	_, _, end, err := readRecords(s.undo)
+	if err != nil {
+		return err
+	}
+	if err := truncate(s.undo, end); err != nil {
+		return err
+	}
+
+	records, offsets, end, err := readRecords(s.blocks)
+	if err != nil {
+		return err
+	}
+	if err := truncate(s.blocks, end); err != nil {
+		return err
+	}
+	expected := make([]indexEntry, 0, len(records))
+	s.known = make(map[string]bool, len(records))
+	for i, data := range records {
+		b := &Block{}
+		if err := json.Unmarshal(data, b); err != nil {
+			return err
+		}
+		expected = append(expected, indexEntry{Hash: b.Hash, PrevHash: b.PrevHash, Index: b.Index, Offset: offsets[i]})
+		s.known[b.Hash] = true
+	}
+
+	records, _, end, err = readRecords(s.index)
+	if err != nil {
+		return err
+	}
+	consistent := len(records) <= len(expected)
+	for i := 0; consistent && i < len(records); i++ {
+		entry := indexEntry{}
+		consistent = json.Unmarshal(records[i], &entry) == nil && entry == expected[i]
+	}
+	if !consistent {
+		log.Println("Block index doesn't match block file, rebuilding it...")
+		records, end = nil, 0
+	}
+	if err := truncate(s.index, end); err != nil {
+		return err
+	}
+	for _, entry := range expected[len(records):] {
+		if _, err := writeRecord(s.index, entry); err != nil {
+			return err
+		}
+	}
+	s.entries = expected
+	return nil
+}
+
+// LoadBlocks returns every stored block, each one after its parent.
+func (s *Store) LoadBlocks() ([]*Block, error) {
+	blocks := make([]*Block, 0, len(s.entries))
+	for _, entry := range s.entries {
+		data, err := readRecordAt(s.blocks, entry.Offset)
+		if err != nil {
+			return nil, err
+		}
+		b := &Block{}
+		if err := json.Unmarshal(data, b); err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, nil
+}
+
+// WriteBlock appends b to the block file and indexes it, unless it is stored already.
+func (s *Store) WriteBlock(b *Block) error {
+	if s.known[b.Hash] {
+		return nil
+	}
+	offset, err := writeRecord(s.blocks, b)
+	if err != nil {
+		return err
+	}
+	entry := indexEntry{Hash: b.Hash, PrevHash: b.PrevHash, Index: b.Index, Offset: offset}
+	if _, err := writeRecord(s.index, entry); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, entry)
+	s.known[b.Hash] = true
+	return nil
+}
+
+// writeUndo appends the undo data of the block with the given hash to the undo file.
+func (s *Store) writeUndo(hash string, undo Undo) error {
+	_, err := writeRecord(s.undo, &undoRecord{Hash: hash, Undo: undo})
+	return err
+}
+
+// loadUndo returns the latest undo data written for each block, by hash.
+func (s *Store) loadUndo() (map[string]Undo, error) {
+	records, _, _, err := readRecords(s.undo)
+	if err != nil {
+		return nil, err
+	}
+	undo := make(map[string]Undo, len(records))
+	for _, data := range records {
+		record := &undoRecord{}
+		if err := json.Unmarshal(data, record); err != nil {
+			return nil, err
+		}
+		undo[record.Hash] = record.Undo
+	}
+	return undo, nil
+}
+
+// loadState reads the ledger snapshot.
+func (s *Store) loadState() (*stateSnapshot, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, stateFile))
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &stateSnapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// writeState replaces the ledger snapshot. The new snapshot is synced to a temporary file first
+// and renamed over the old one, so a crash leaves either of them whole.
+func (s *Store) writeState(snapshot *stateSnapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(filepath.Join(s.dir, stateFile), data)
+}
+
+func (s *Store) Close() error {
+	err := s.blocks.Close()
+	if indexErr := s.index.Close(); err == nil {
+		err = indexErr
+	}
+	if undoErr := s.undo.Close(); err == nil {
+		err = undoErr
+	}
+	return err
+}
+
+// WriteFileAtomic writes data to a temporary file, syncs it and renames it to name, so that a crash leaves
+// either the previous file or the new one whole.
+func WriteFileAtomic(name string, data []byte) error {
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// writeRecord appends the JSON encoding of v to f, syncs f and returns the offset of the record.
+func writeRecord(f *os.File, v interface{}) (int64, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	record := make([]byte, recordHeaderSize, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	record = append(record, data...)
+	if _, err := f.Write(record); err != nil {
+		return 0, err
+	}
+	return offset, f.Sync()
+}
+
+// readRecords reads the records of f up to the first torn or corrupt one.
+// It returns them with their offsets and the offset where the intact records end.
+func readRecords(f *os.File) (records [][]byte, offsets []int64, end int64, err error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, 0, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	for {
+		record, ok := parseRecord(data[end:])
+		if !ok {
+			break
+		}
+		records = append(records, record)
+		offsets = append(offsets, end)
+		end += int64(recordHeaderSize + len(record))
+	}
+	if end != int64(len(data)) {
+		log.Printf("Dropping %d bytes of torn records at the end of %s", int64(len(data))-end, f.Name())
+	}
+	return records, offsets, end, nil
+}
+
+func readRecordAt(f *os.File, offset int64) ([]byte, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return nil, err
+	}
+	data := make([]byte, recordHeaderSize+binary.BigEndian.Uint32(header[0:4]))
+	if _, err := f.ReadAt(data, offset); err != nil {
+		return nil, err
+	}
+	record, ok := parseRecord(data)
+	if !ok {
+		return nil, errors.New("corrupt record in " + f.Name())
+	}
+	return record, nil
+}
+
+// parseRecord returns the payload of the record at the start of data if it is whole and its checksum matches.
+func parseRecord(data []byte) ([]byte, bool) {
+	if len(data) < recordHeaderSize {
+		return nil, false
+	}
+	length := int(binary.BigEndian.Uint32(data[0:4]))
+	if len(data)-recordHeaderSize < length {
+		return nil, false
+	}
+	record := data[recordHeaderSize : recordHeaderSize+length]
+	if crc32.ChecksumIEEE(record) != binary.BigEndian.Uint32(data[4:8]) {
+		return nil, false
+	}
+	return record, true
+}
+
+func truncate(f *os.File, size int64) error {
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	_, err := f.Seek(size, io.SeekStart)
+	return err
+}
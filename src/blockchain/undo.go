@@ -0,0 +1,55 @@
+package blockchain
+
+// AccountUndo records what an account looked like before a block touched it.
+type AccountUndo struct {
+	Address string
+	Prev    *Account // nil when the block created the account
+}
+
+// undoLog hands out the accounts a block execution modifies, saving each one the first time it is touched.
+type undoLog struct {
+	accounts State
+	seen     map[string]bool
+	records  []AccountUndo
+}
+
+func newUndoLog(accounts State) *undoLog {
+	return &undoLog{
+		accounts: accounts,
+		seen:     make(map[string]bool),
+	}
+}
+
+// touch returns the account at addr, creating it with a zero balance if needed.
+func (u *undoLog) touch(addr string) *Account {
+	acc, exists := u.accounts[addr]
+	if !u.seen[addr] {
+		u.seen[addr] = true
+		rec := AccountUndo{Address: addr}
+		if exists {
+			prev := *acc
+			rec.Prev = &prev
+		}
+		u.records = append(u.records, rec)
+	}
+	if !exists {
+		acc = &Account{Address: addr}
+		u.accounts[addr] = acc
+	}
+	return acc
+}
+
+// revertBlock undoes a block execution in place, restoring accounts in reverse order of modification.
+func revertBlock(accounts State, undo []AccountUndo) {
+	for i := len(undo) - 1; i >= 0; i-- {
+		rec := undo[i]
+		if rec.Prev == nil {
+			delete(accounts, rec.Address)
+		} else if acc, exists := accounts[rec.Address]; exists {
+			*acc = *rec.Prev
+		} else {
+			prev := *rec.Prev
+			accounts[rec.Address] = &prev
+		}
+	}
+}
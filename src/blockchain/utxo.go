@@ -0,0 +1,256 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// MAX_INPUTS bounds the number of inputs of a transaction.
+const MAX_INPUTS = 256
+
+// UTXO is an unspent output, created by the block at Height.
+type UTXO struct {
+	Output
+	Height   uint64
+	Coinbase bool // coinbase outputs can only be spent after CoinbaseMaturity blocks
+}
+
+// UTXOSet is the ledger of the unspent outputs of the main chain, by outpoint.
+// Transactions spend whole outputs and create new ones, so every output is spent at most once and nonces
+// are not needed ; they must be 0.
+type UTXOSet struct {
+	Outputs  map[string]*UTXO
+	Height   uint64 // index of the last block applied
+	maturity uint64 // CoinbaseMaturity of the network
+}
+
+// OutputUndo records what an outpoint held before a block spent or created it.
+type OutputUndo struct {
+	OutPoint string
+	Prev     *UTXO // nil when the block created the output
+}
+
+// genesisOutputs returns the outputs created by the genesis block, one per allocation in address order.
+func (params *ChainParams) genesisOutputs() *UTXOSet {
+	addrs := make([]string, 0, len(params.Allocations))
+	for addr := range params.Allocations {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	s := &UTXOSet{Outputs: make(map[string]*UTXO, len(addrs)), maturity: params.CoinbaseMaturity}
+	for i, addr := range addrs {
+		s.Outputs[OutPoint{Index: i}.String()] = &UTXO{Output: Output{Address: addr, Amount: params.Allocations[addr]}}
+	}
+	return s
+}
+
+// ApplyBlock spends the inputs and creates the outputs of the transactions of b. Coinbase outputs don't need
+// to be matured, so matured is ignored.
+func (s *UTXOSet) ApplyBlock(b *Block, params *ChainParams, matured *Transaction) (Undo, error) {
+	var undo []OutputUndo
+	err := func() error {
+		if b.Index != s.Height+1 {
+			return fmt.Errorf("block %d does not follow block %d", b.Index, s.Height)
+		}
+		coinbase := b.Coinbase()
+		if coinbase == nil {
+			return errors.New("first transaction is not a coinbase")
+		}
+
+		reward := params.Subsidy(b.Index)
+		for i := 1; i < len(b.Txns); i++ {
+			if b.Txns[i].IsCoinbase() {
+				return fmt.Errorf("transaction %s is a second coinbase", b.Txns[i].Hash)
+			}
+			records, err := s.applyTransaction(&b.Txns[i], b.Index)
+			undo = append(undo, records...)
+			if err == nil {
+				reward, err = add(reward, b.Txns[i].Fee)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := checkCoinbase(coinbase, b.Index, reward); err != nil {
+			return err
+		}
+		records, err := s.createOutputs(coinbase, b.Index, true)
+		undo = append(undo, records...)
+		return err
+	}()
+
+	if err != nil {
+		s.revert(undo)
+		return Undo{}, fmt.Errorf("%w : %v", ErrInvalidBlock, err)
+	}
+	s.Height = b.Index
+	return Undo{Outputs: undo}, nil
+}
+
+func (s *UTXOSet) RevertBlock(undo Undo) {
+	s.revert(undo.Outputs)
+	s.Height--
+}
+
+func (s *UTXOSet) FilterTxns(txns []Transaction, index uint64) []Transaction {
+	var undo []OutputUndo
+	defer func() { s.revert(undo) }()
+
+	valid := make([]Transaction, 0, len(txns))
+	for i := range txns {
+		records, err := s.applyTransaction(&txns[i], index)
+		undo = append(undo, records...)
+		if err == nil {
+			valid = append(valid, txns[i])
+		}
+	}
+	return valid
+}
+
+// Account sums the outputs of addr that can be spent in the next block. Its nonce is always 0.
+func (s *UTXOSet) Account(addr string) Account {
+	acc := Account{Address: addr}
+	for _, out := range s.Outputs {
+		if out.Address != addr {
+			continue
+		}
+		if !s.spendable(out, s.Height+1) {
+			acc.Immature += out.Amount
+		} else {
+			acc.Balance += out.Amount
+		}
+	}
+	return acc
+}
+
+// Root hashes the outputs in outpoint order so that every node computes the same root.
+func (s *UTXOSet) Root() string {
+	keys := make([]string, 0, len(s.Outputs))
+	for key := range s.Outputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	// encoded like State.Root, so that no two sets encode the same
+	var buf bytes.Buffer
+	writeUint := func(v uint64) {
+		binary.Write(&buf, binary.BigEndian, v)
+	}
+	writeString := func(str string) {
+		writeUint(uint64(len(str)))
+		buf.WriteString(str)
+	}
+	writeUint(uint64(len(keys)))
+	for _, key := range keys {
+		out := s.Outputs[key]
+		writeString(key)
+		writeString(out.Address)
+		writeUint(out.Amount)
+		writeUint(out.Height)
+		if out.Coinbase {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	}
+	h := sha256.Sum256(buf.Bytes())
+
+	return hex.EncodeToString(h[:])
+}
+
+func (s *UTXOSet) Supply() uint64 {
+	var supply uint64
+	for _, out := range s.Outputs {
+		supply += out.Amount
+	}
+	return supply
+}
+
+// applyTransaction spends the inputs of t and creates its outputs in the block at index. The sender must own
+// every input and the inputs must sum to the outputs and the fee. Nothing is modified if t can't be executed.
+func (s *UTXOSet) applyTransaction(t *Transaction, index uint64) ([]OutputUndo, error) {
+	if len(t.Inputs) == 0 || len(t.Inputs) > MAX_INPUTS {
+		return nil, fmt.Errorf("transaction %s has %d inputs, expected 1 to %d", t.Hash, len(t.Inputs), MAX_INPUTS)
+	}
+	if t.Nonce != 0 {
+		return nil, fmt.Errorf("transaction %s has nonce %d, UTXO transactions use 0", t.Hash, t.Nonce)
+	}
+	cost, err := t.Cost()
+	if err != nil {
+		return nil, err
+	}
+
+	var total uint64
+	spent := make(map[string]bool, len(t.Inputs))
+	for _, in := range t.Inputs {
+		key := in.String()
+		out, exists := s.Outputs[key]
+		if !exists || spent[key] {
+			return nil, fmt.Errorf("transaction %s spends missing or spent output %s", t.Hash, key)
+		}
+		if out.Address != t.Sender {
+			return nil, fmt.Errorf("transaction %s spends output %s it doesn't own", t.Hash, key)
+		}
+		if !s.spendable(out, index) {
+			return nil, fmt.Errorf("transaction %s spends immature coinbase output %s", t.Hash, key)
+		}
+		spent[key] = true
+		if total, err = add(total, out.Amount); err != nil {
+			return nil, err
+		}
+	}
+	if total != cost {
+		return nil, fmt.Errorf("transaction %s spends %d but its outputs and fee sum to %d", t.Hash, total, cost)
+	}
+	for i := range t.Outputs {
+		if _, exists := s.Outputs[OutPoint{Hash: t.Hash, Index: i}.String()]; exists {
+			return nil, fmt.Errorf("transaction %s creates existing outputs", t.Hash)
+		}
+	}
+
+	undo := make([]OutputUndo, 0, len(t.Inputs)+len(t.Outputs))
+	for _, in := range t.Inputs {
+		key := in.String()
+		undo = append(undo, OutputUndo{OutPoint: key, Prev: s.Outputs[key]})
+		delete(s.Outputs, key)
+	}
+	records, err := s.createOutputs(t, index, false)
+	return append(undo, records...), err
+}
+
+// createOutputs adds the outputs of t to the set.
+func (s *UTXOSet) createOutputs(t *Transaction, index uint64, coinbase bool) ([]OutputUndo, error) {
+	undo := make([]OutputUndo, 0, len(t.Outputs))
+	for i, o := range t.Outputs {
+		key := OutPoint{Hash: t.Hash, Index: i}.String()
+		if _, exists := s.Outputs[key]; exists {
+			return undo, fmt.Errorf("output %s already exists", key)
+		}
+		s.Outputs[key] = &UTXO{Output: o, Height: index, Coinbase: coinbase}
+		undo = append(undo, OutputUndo{OutPoint: key})
+	}
+	return undo, nil
+}
+
+// spendable reports whether out can be spent in the block at index : coinbase outputs must have matured.
+func (s *UTXOSet) spendable(out *UTXO, index uint64) bool {
+	return !out.Coinbase || (index >= out.Height && index-out.Height >= s.maturity)
+}
+
+// revert undoes the changes recorded in undo, in reverse order.
+func (s *UTXOSet) revert(undo []OutputUndo) {
+	for i := len(undo) - 1; i >= 0; i-- {
+		if undo[i].Prev == nil {
+			delete(s.Outputs, undo[i].OutPoint)
+		} else {
+			s.Outputs[undo[i].OutPoint] = undo[i].Prev
+		}
+	}
+}
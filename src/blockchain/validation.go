@@ -0,0 +1,148 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"fmt"
+	"ketcoin/src/crypto"
+	"time"
+)
+
+// MAX_FUTURE_DRIFT bounds how far ahead of the local clock a block timestamp may be.
+const MAX_FUTURE_DRIFT = 2 * time.Hour
+
+// MAX_OUTPUTS bounds the number of outputs of a transaction.
+const MAX_OUTPUTS = 256
+
+// MAX_DATA_SIZE bounds the size of the memo of a transaction.
+const MAX_DATA_SIZE = 256
+
+// VerifySignature checks that the Transaction's hash matches its content and was signed by its sender,
+// or that its witness unlocks the sender's script address.
+func (t *Transaction) VerifySignature() bool {
+	if t.Hash != t.ComputeHash() {
+		return false
+	}
+	if t.Witness != nil {
+		return t.Signature == nil && t.VerifyWitness() == nil
+	}
+	if t.Signature == nil {
+		return false
+	}
+	addr, err := hex.DecodeString(t.Sender)
+	if err != nil || len(addr) != 32 {
+		return false
+	}
+	hash, err := hex.DecodeString(t.Hash)
+	if err != nil {
+		return false
+	}
+	return crypto.Verify(t.Signature, *(*[32]byte)(addr), *(*[32]byte)(hash))
+}
+
+// CheckOutputs checks that the Transaction pays between 1 and MAX_OUTPUTS outputs, each to an address.
+func (t *Transaction) CheckOutputs() error {
+	if len(t.Outputs) == 0 || len(t.Outputs) > MAX_OUTPUTS {
+		return fmt.Errorf("transaction %s has %d outputs, expected 1 to %d", t.Hash, len(t.Outputs), MAX_OUTPUTS)
+	}
+	for _, o := range t.Outputs {
+		if o.Address == "" {
+			return fmt.Errorf("transaction %s pays an empty address", t.Hash)
+		}
+	}
+	return nil
+}
+
+// CheckData checks that the memo of the Transaction fits in MAX_DATA_SIZE and that its fee pays for it.
+func (t *Transaction) CheckData(params *ChainParams) error {
+	if len(t.Data) > MAX_DATA_SIZE {
+		return fmt.Errorf("transaction %s has %d bytes of data, at most %d allowed", t.Hash, len(t.Data), MAX_DATA_SIZE)
+	}
+	if fee := params.DataFee(t); t.Fee < fee {
+		return fmt.Errorf("transaction %s pays %d for %d bytes of data, expected %d", t.Hash, t.Fee, len(t.Data), fee)
+	}
+	return nil
+}
+
+// CheckLocks checks that the Transaction can be included in the block at index whose parent has the given
+// timestamp.
+func (t *Transaction) CheckLocks(index uint64, parentTime time.Time) error {
+	// the hash only covers whole seconds
+	if t.LockTime.Nanosecond() != 0 {
+		return fmt.Errorf("transaction %s has a lock time with fractions of a second", t.Hash)
+	}
+	if index < t.LockHeight {
+		return fmt.Errorf("transaction %s is locked until index %d", t.Hash, t.LockHeight)
+	}
+	if parentTime.Before(t.LockTime) {
+		return fmt.Errorf("transaction %s is locked until %s", t.Hash, t.LockTime)
+	}
+	if t.Expired(index) {
+		return fmt.Errorf("transaction %s expired at index %d", t.Hash, t.ExpiryHeight)
+	}
+	return nil
+}
+
+// Expired reports whether the Transaction can't be included in the block at index or any later one.
+func (t *Transaction) Expired(index uint64) bool {
+	return t.ExpiryHeight != 0 && index > t.ExpiryHeight
+}
+
+// checkSeal checks the parts of a block header that don't depend on its parent, the consensus fields included.
+func checkSeal(b *Block, params *ChainParams) error {
+	if b.Hash != b.ComputeHash() {
+		return fmt.Errorf("%w : hash does not match header", ErrInvalidBlock)
+	}
+	if err := params.Engine().VerifyHeader(b, params); err != nil {
+		return err
+	}
+	if b.Timestamp.After(time.Now().Add(MAX_FUTURE_DRIFT)) {
+		return fmt.Errorf("%w : timestamp too far in the future", ErrInvalidBlock)
+	}
+	return nil
+}
+
+// checkHeader validates the header of b as a child of parent.
+func checkHeader(b, parent *Block, params *ChainParams) error {
+	if err := checkSeal(b, params); err != nil {
+		return err
+	}
+	if b.PrevHash != parent.Hash || b.Index != parent.Index+1 {
+		return fmt.Errorf("%w : index %d does not follow parent index %d", ErrInvalidBlock, b.Index, parent.Index)
+	}
+	if b.Timestamp.Before(parent.Timestamp) {
+		return fmt.Errorf("%w : timestamp earlier than parent's", ErrInvalidBlock)
+	}
+	return nil
+}
+
+// checkTxns verifies the signatures of the transactions of b, but for its coinbase, that their locks allow them
+// in b, that their fees pay for their data and that none appears twice. parent is the block b extends.
+func checkTxns(b, parent *Block, params *ChainParams) error {
+	seen := make(map[string]bool, len(b.Txns))
+	for i := range b.Txns {
+		t := &b.Txns[i]
+		if seen[t.Hash] {
+			return fmt.Errorf("%w : transaction %s included twice", ErrInvalidBlock, t.Hash)
+		}
+		seen[t.Hash] = true
+		if err := t.CheckOutputs(); err != nil {
+			return fmt.Errorf("%w : %v", ErrInvalidBlock, err)
+		}
+		if err := t.CheckLocks(b.Index, parent.Timestamp); err != nil {
+			return fmt.Errorf("%w : %v", ErrInvalidBlock, err)
+		}
+		if err := t.CheckData(params); err != nil {
+			return fmt.Errorf("%w : %v", ErrInvalidBlock, err)
+		}
+		if t.IsCoinbase() {
+			if i != 0 || t.Hash != t.ComputeHash() {
+				return fmt.Errorf("%w : misplaced or altered coinbase %s", ErrInvalidBlock, t.Hash)
+			}
+			continue
+		}
+		if !t.VerifySignature() {
+			return fmt.Errorf("%w : invalid signature on transaction %s", ErrInvalidBlock, t.Hash)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+// Package consensus holds the engines deciding how blocks are built, sealed and verified.
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"ketcoin/src/blockchain"
+)
+
+// Chain is the main chain blocks are built on.
+type Chain interface {
+	GetLastBlock() *blockchain.Block
+	ComputeStateRoot(b *blockchain.Block) (string, error)
+}
+
+// Backend is the node handing out blocks to seal, to the local miner as well as to pool workers.
+type Backend interface {
+	// BlockTemplate returns the block to mine on top of the tip, or nil when there is nothing worth mining.
+	BlockTemplate() *blockchain.Block
+	// SubmitBlock hands over a block meeting its difficulty and reports whether it was accepted.
+	SubmitBlock(b *blockchain.Block) bool
+}
+
+// Engine builds, seals and verifies the blocks of a network. Engines register themselves with
+// blockchain.RegisterEngine when this package is initialized, under the name ChainParams.Consensus refers to.
+type Engine interface {
+	blockchain.Engine
+	// Prepare sets the consensus fields of the header of b, which extends the tip of chain.
+	Prepare(chain Chain, b *blockchain.Block, params *blockchain.ChainParams) error
+	// Finalize completes b once its transactions are chosen : it adds the coinbase paying beneficiary
+	// and commits to the resulting state.
+	Finalize(chain Chain, b *blockchain.Block, params *blockchain.ChainParams, beneficiary string) error
+	// Seal returns a copy of b carrying its proof, or ctx.Err() once ctx is cancelled.
+	// It may be called from several goroutines on the same block : worker is the index of the caller among
+	// workers goroutines, which search disjoint parts of the solutions.
+	Seal(ctx context.Context, b *blockchain.Block, worker, workers int) (*blockchain.Block, error)
+}
+
+// HashCounter is implemented by the engines whose sealing has a hashrate.
+type HashCounter interface {
+	// Hashes returns the number of hashes Seal tried so far.
+	Hashes() uint64
+}
+
+// Targeter is implemented by the engines sealing blocks with a hash meeting a target, which external miners and
+// pool workers can look for.
+type Targeter interface {
+	// Target returns the prefix the hash of b starts with once sealed.
+	Target(b *blockchain.Block) string
+	// ShareTarget returns the prefix of the hashes of b needing about 16^difficulty tries, or Target(b) when
+	// it is easier.
+	ShareTarget(b *blockchain.Block, difficulty int) string
+}
+
+// Get returns the engine of the network of params.
+func Get(params *blockchain.ChainParams) (Engine, error) {
+	e, ok := params.Engine().(Engine)
+	if !ok {
+		return nil, fmt.Errorf("no consensus engine %q", params.Consensus)
+	}
+	return e, nil
+}
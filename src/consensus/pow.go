@@ -0,0 +1,87 @@
+package consensus
+
+import (
+	"context"
+	"fmt"
+	"ketcoin/src/blockchain"
+	"math/big"
+	"strings"
+	"sync/atomic"
+)
+
+// checkInterval is the number of hashes Seal tries between checks for cancellation.
+const checkInterval = 1000
+
+func init() {
+	blockchain.RegisterEngine(blockchain.ProofOfWork, &PoW{})
+}
+
+// PoW requires the hash of a block to start with as many zero hex digits as the Difficulty of its network.
+type PoW struct {
+	hashes uint64 // accessed atomically
+}
+
+func (e *PoW) Prepare(chain Chain, b *blockchain.Block, params *blockchain.ChainParams) error {
+	b.Difficulty = params.Difficulty
+	b.Nonce = 0
+	return nil
+}
+
+func (e *PoW) Finalize(chain Chain, b *blockchain.Block, params *blockchain.ChainParams, beneficiary string) error {
+	coinbase := blockchain.NewCoinbase(b.Index, beneficiary, params.Subsidy(b.Index)+b.Fees())
+	b.Txns = append([]blockchain.Transaction{coinbase}, b.Txns...)
+	root, err := chain.ComputeStateRoot(b)
+	if err != nil {
+		return err
+	}
+	b.StateRoot = root
+	return nil
+}
+
+// Seal tries the nonces equal to worker modulo workers, so that concurrent calls don't try the same ones.
+func (e *PoW) Seal(ctx context.Context, b *blockchain.Block, worker, workers int) (*blockchain.Block, error) {
+	sealed := *b
+	var tried uint64 // not added to e.hashes yet
+	defer func() { atomic.AddUint64(&e.hashes, tried) }()
+	for sealed.Nonce = worker; ; sealed.Nonce += workers {
+		if tried == checkInterval {
+			atomic.AddUint64(&e.hashes, tried)
+			tried = 0
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		tried++
+		if sealed.MeetsDifficulty() {
+			sealed.Hash = sealed.ComputeHash()
+			return &sealed, nil
+		}
+	}
+}
+
+func (e *PoW) VerifyHeader(b *blockchain.Block, params *blockchain.ChainParams) error {
+	if b.Difficulty != params.Difficulty || !b.MeetsDifficulty() {
+		return fmt.Errorf("%w : bad proof of work", blockchain.ErrInvalidBlock)
+	}
+	return nil
+}
+
+// Work is the expected number of hashes it took to seal b.
+func (e *PoW) Work(b *blockchain.Block) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(4*b.Difficulty))
+}
+
+func (e *PoW) Target(b *blockchain.Block) string {
+	return strings.Repeat("0", b.Difficulty)
+}
+
+func (e *PoW) ShareTarget(b *blockchain.Block, difficulty int) string {
+	if b.Difficulty < difficulty {
+		difficulty = b.Difficulty
+	}
+	return strings.Repeat("0", difficulty)
+}
+
+func (e *PoW) Hashes() uint64 {
+	return atomic.LoadUint64(&e.hashes)
+}
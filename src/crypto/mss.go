@@ -2,6 +2,7 @@ package crypto
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"math"
@@ -104,7 +105,9 @@ func (tree *MerkleSigTree) Sign(digest [n]byte) *MssSignature {
 }
 
 func Verify(signature *MssSignature, mssPublicKey [n]byte, digest [n]byte) bool {
-	wotsVerify(signature.OtsSignature, signature.OtsPublicKey, digest)
+	if !wotsVerify(signature.OtsSignature, signature.OtsPublicKey, digest) {
+		return false
+	}
 
 	// verify authenticity of the OTS public key by computing the root hash from the auth path
 	// at the end of the loop, authPathHash is the hash tree root of the signer, which is also its public key
@@ -159,6 +162,49 @@ func (mss *MerkleSigTree) MarshalJSON() ([]byte, error) {
 	return j, nil
 }
 
+// SignatureSize is the size of an MssSignature encoded by Bytes.
+const SignatureSize = 8 + 2*t*n + height*n
+
+// Bytes encodes the signature as its index followed by the one-time signature, the one-time public key
+// and the authentication path.
+func (signature *MssSignature) Bytes() []byte {
+	data := make([]byte, 8, SignatureSize)
+	binary.BigEndian.PutUint64(data, uint64(signature.Index))
+	for i := 0; i < t; i++ {
+		data = append(data, signature.OtsSignature[i][:]...)
+	}
+	for i := 0; i < t; i++ {
+		data = append(data, signature.OtsPublicKey[i][:]...)
+	}
+	for i := 0; i < height; i++ {
+		data = append(data, signature.AuthPath[i][:]...)
+	}
+	return data
+}
+
+// DecodeSignature decodes a signature encoded by Bytes.
+func DecodeSignature(data []byte) (*MssSignature, error) {
+	if len(data) != SignatureSize {
+		return nil, fmt.Errorf("signature is %d bytes long, expected %d", len(data), SignatureSize)
+	}
+	index := binary.BigEndian.Uint64(data)
+	if index >= nbMessages {
+		return nil, fmt.Errorf("signature index %d out of range", index)
+	}
+	signature := &MssSignature{Index: int(index)}
+	data = data[8:]
+	for i := 0; i < t; i++ {
+		data = data[copy(signature.OtsSignature[i][:], data):]
+	}
+	for i := 0; i < t; i++ {
+		data = data[copy(signature.OtsPublicKey[i][:], data):]
+	}
+	for i := 0; i < height; i++ {
+		data = data[copy(signature.AuthPath[i][:], data):]
+	}
+	return signature, nil
+}
+
 func GetByteArrayAsString(array [t][n]byte) string {
 	var s []byte
 	for i, row := range array {
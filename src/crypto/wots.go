@@ -91,10 +91,11 @@ func wotsSign(wots *oneTimeSig, digest [n]byte) [t][n]byte {
 	return signature
 }
 
-func wotsVerify(signature [t][n]byte, publicKey [t][n]byte, digest [n]byte) {
+func wotsVerify(signature [t][n]byte, publicKey [t][n]byte, digest [n]byte) bool {
 	var bitStrings = computeBitStrings(digest)
-	error := false
+	valid := true
 	for i := 0; i < t; i++ {
+		error := false
 		verif := signature[i]
 		for j := uint32(0); j < uint32(math.Pow(2, w))-1-uint32(bitStrings[i]); j++ {
 			verif = sha256.Sum256(verif[:])
@@ -102,11 +103,12 @@ func wotsVerify(signature [t][n]byte, publicKey [t][n]byte, digest [n]byte) {
 		for j := 0; j < len(verif); j++ {
 			if verif[j] != publicKey[i][j] {
 				error = true
-				// TODO : handle invalid signatures
 			}
 		}
 		if error {
 			fmt.Printf("Invalid WOTS signature : \nwots verification=%x\npublic key=%x\n", verif, publicKey[i])
+			valid = false
 		}
 	}
+	return valid
 }
@@ -2,8 +2,14 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"ketcoin/src/blockchain"
 	"ketcoin/src/p2p"
 	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 )
 
 func main() {
@@ -14,6 +20,15 @@ func initNode() {
 	listenPort := flag.Int("l", 0, "Port to listen on for new connections")
 	target := flag.String("t", "", "Target peer to connect to at first")
 	keys := flag.String("k", "", "File containing key information in JSON format")
+	dataDir := flag.String("d", "", "Directory storing the blockchain (defaults to data-<network>-<port>)")
+	network := flag.String("network", "mainnet", "Network to join : mainnet, testnet, regtest or regtest-utxo")
+	mine := flag.Bool("mine", true, "Mine blocks")
+	nomine := flag.Bool("nomine", false, "Only relay blocks and transactions, same as -mine=false")
+	threads := flag.Int("threads", runtime.NumCPU(), "Number of mining threads")
+	emptyBlocks := flag.Bool("empty", false, "Mine blocks with only a coinbase when no transaction is pending")
+	blockInterval := flag.Duration("blockinterval", 0, "Minimum time between the tip and a mined block, such as 30s (defaults to the block time of the network)")
+	poolPort := flag.Int("pool", 0, "Port to serve mining work to pool workers on (disabled by default)")
+	shareDifficulty := flag.Int("sharediff", 2, "Leading zero hex digits of the hash of a pool share")
 
 	flag.Parse()
 
@@ -21,10 +36,37 @@ func initNode() {
 		log.Fatal("Please provide a port to listen on with -l")
 	}
 
-	node := p2p.MakeNode(uint16(*listenPort))
-	node.Init(target, keys)
+	params, err := blockchain.GetChainParams(*network)
+	if err != nil {
+		log.Fatal(err)
+	}
+	blockIntervalSet := false
+	flag.Visit(func(f *flag.Flag) { blockIntervalSet = blockIntervalSet || f.Name == "blockinterval" })
+	if !blockIntervalSet {
+		*blockInterval = params.BlockTime
+	}
+	if *dataDir == "" {
+		*dataDir = fmt.Sprintf("data-%s-%d", params.Name, *listenPort)
+	}
+
+	node := p2p.MakeNode(uint16(*listenPort), params, p2p.MiningConfig{
+		Enabled:     *mine && !*nomine,
+		Threads:     *threads,
+		EmptyBlocks: *emptyBlocks,
+		MinInterval: *blockInterval,
+	})
+	node.Init(target, keys, dataDir)
+	if *poolPort != 0 {
+		if err := node.StartPool(uint16(*poolPort), *shareDifficulty); err != nil {
+			log.Fatalf("Error starting pool : %v", err)
+		}
+	}
 	go node.Start()
 
 	log.Printf("Try connecting to this node using \"./src -l %d -t 127.0.0.1:%d\"", *listenPort+1, *listenPort)
-	select {}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	log.Printf("Received %v, shutting down...", <-interrupt)
+	node.Shutdown()
 }
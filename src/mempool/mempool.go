@@ -0,0 +1,510 @@
+// Package mempool holds the transactions waiting to be included in a block.
+package mempool
+
+import (
+	"errors"
+	"fmt"
+	"ketcoin/src/blockchain"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrKnown       = errors.New("transaction already in the mempool")
+	ErrNonceUsed   = errors.New("transaction nonce already used")
+	ErrExpired     = errors.New("transaction expired")
+	ErrLocked      = errors.New("transaction locked in the next block")
+	ErrSenderLimit = errors.New("too many pending transactions from sender")
+	ErrFull        = errors.New("mempool full and transaction fee rate too low")
+	ErrConflict    = errors.New("transaction conflicts with pending ones and doesn't pay enough to replace them")
+	ErrOvercommit  = errors.New("pending transactions of sender spend more than its balance")
+	ErrInputs      = errors.New("transaction inputs can't be spent on the tip")
+)
+
+// Chain is what the mempool needs to know about the main chain tip.
+type Chain interface {
+	GetAccount(addr string) blockchain.Account
+	GetLastBlock() *blockchain.Block
+	CheckInputs(t *blockchain.Transaction) error
+}
+
+// Config bounds the mempool.
+type Config struct {
+	MaxCount     int           // transactions
+	MaxBytes     int           // encoded size of the transactions
+	MaxPerSender int           // transactions of a single sender
+	TTL          time.Duration // time after which a transaction is dropped
+	// FeeBump is what a transaction must pay on top of the fees of the transactions it conflicts with to replace them.
+	FeeBump uint64
+}
+
+var DefaultConfig = Config{
+	MaxCount:     5000,
+	MaxBytes:     16 << 20,
+	MaxPerSender: 64,
+	TTL:          72 * time.Hour,
+	FeeBump:      10,
+}
+
+// Mempool keeps transactions executable in nonce order apart from the ones waiting for a lower nonce.
+// When it is full, the transaction with the lowest fee rate among the last ones of each sender is evicted.
+//
+// Two transactions conflict when they have the same sender and nonce, or on UTXO ledgers when they spend
+// the same output. Only one of them is kept : a new transaction replaces the ones it conflicts with if it pays
+// at least FeeBump more than their fees. The transactions of a sender can't spend more than its balance, and on
+// UTXO ledgers transactions only spend unspent outputs of the tip.
+// It is safe for concurrent use.
+type Mempool struct {
+	mutex   sync.RWMutex
+	chain   Chain
+	config  Config
+	nonces  bool                // false on UTXO ledgers, where every transaction is executable
+	pending map[string]*entry   // executable in nonce order, by hash
+	queued  map[string]*entry   // waiting for a lower nonce, by hash
+	senders map[string][]*entry // pending and queued transactions of each sender, in nonce order
+	bytes   int
+}
+
+type entry struct {
+	tx      blockchain.Transaction
+	size    int
+	feeRate float64 // fee per byte of size
+	added   time.Time
+}
+
+func newEntry(t blockchain.Transaction) *entry {
+	size := t.Size()
+	return &entry{tx: t, size: size, feeRate: float64(t.Fee) / float64(size), added: time.Now()}
+}
+
+func New(chain Chain, params *blockchain.ChainParams, config Config) *Mempool {
+	return &Mempool{
+		chain:   chain,
+		config:  config,
+		nonces:  params.Ledger == blockchain.AccountModel,
+		pending: make(map[string]*entry),
+		queued:  make(map[string]*entry),
+		senders: make(map[string][]*entry),
+	}
+}
+
+// Add puts t in the mempool if it follows the sender's last pending nonce, and queues it otherwise.
+// It replaces the transactions t conflicts with. t must have been validated against the tip.
+func (mp *Mempool) Add(t blockchain.Transaction) error {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	mp.expire(time.Now())
+	if mp.has(t.Hash) {
+		return ErrKnown
+	}
+	conflicts := mp.conflicts(&t)
+	replaced := make(map[string]bool, len(conflicts))
+	var fees uint64
+	for _, e := range conflicts {
+		replaced[e.tx.Hash] = true
+		fees += e.tx.Fee
+	}
+	if len(conflicts) > 0 && t.Fee < fees+mp.config.FeeBump {
+		return ErrConflict
+	}
+	count := 0
+	for _, e := range mp.bySender(t.Sender) {
+		if !replaced[e.tx.Hash] {
+			count++
+		}
+	}
+	if count >= mp.config.MaxPerSender {
+		return ErrSenderLimit
+	}
+	if mp.nonces && !mp.affordable(&t, replaced) {
+		return ErrOvercommit
+	}
+
+	for _, e := range conflicts {
+		log.Printf("Replacing transaction %s by %s in the mempool", e.tx.Hash, t.Hash)
+		mp.remove(e.tx.Hash)
+	}
+	if err := mp.insert(newEntry(t)); err != nil {
+		mp.restore(conflicts, t.Hash)
+		return err
+	}
+	mp.promote()
+	evicted := mp.enforceLimits()
+	for _, e := range evicted {
+		if e.tx.Hash == t.Hash {
+			// the mempool fit in its limits without t, so it does again once everything t pushed out is back
+			mp.restore(append(conflicts, evicted...), t.Hash)
+			return ErrFull
+		}
+	}
+	return nil
+}
+
+// Update drops the transactions confirmed by connected, then sorts the remaining ones again against the new
+// tip, evicting the expired and the locked ones.
+func (mp *Mempool) Update(connected []*blockchain.Block) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+	for _, b := range connected {
+		for _, t := range b.Txns {
+			mp.remove(t.Hash)
+		}
+	}
+
+	entries := make([]*entry, 0, len(mp.pending)+len(mp.queued))
+	for _, e := range mp.pending {
+		entries = append(entries, e)
+	}
+	for _, e := range mp.queued {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tx.Nonce < entries[j].tx.Nonce })
+	mp.pending = make(map[string]*entry)
+	mp.queued = make(map[string]*entry)
+	mp.senders = make(map[string][]*entry)
+	mp.bytes = 0
+	for _, e := range entries {
+		if err := mp.insert(e); err != nil {
+			log.Printf("Dropping transaction %s from the mempool ; %v", e.tx.Hash, err)
+		}
+	}
+	mp.promote()
+	mp.expire(time.Now())
+	mp.dropOvercommitted()
+}
+
+// Pending returns the executable transactions by decreasing fee rate, keeping each sender's transactions
+// in nonce order.
+func (mp *Mempool) Pending() []blockchain.Transaction {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+
+	bySender := make(map[string][]*entry)
+	for sender, entries := range mp.senders {
+		for _, e := range entries {
+			if _, pending := mp.pending[e.tx.Hash]; pending {
+				bySender[sender] = append(bySender[sender], e)
+			}
+		}
+	}
+
+	txns := make([]blockchain.Transaction, 0, len(mp.pending))
+	for len(bySender) > 0 {
+		best := ""
+		for sender, pending := range bySender {
+			if best == "" || pending[0].feeRate > bySender[best][0].feeRate {
+				best = sender
+			}
+		}
+		txns = append(txns, bySender[best][0].tx)
+		if bySender[best] = bySender[best][1:]; len(bySender[best]) == 0 {
+			delete(bySender, best)
+		}
+	}
+	return txns
+}
+
+// Count returns the number of executable transactions.
+func (mp *Mempool) Count() int {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+	return len(mp.pending)
+}
+
+// Size returns the number of transactions held, queued ones included, and their encoded size.
+func (mp *Mempool) Size() (int, int) {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+	return len(mp.pending) + len(mp.queued), mp.bytes
+}
+
+// Get returns the transaction with the given hash, queued ones included.
+func (mp *Mempool) Get(hash string) (blockchain.Transaction, bool) {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+	e, exists := mp.pending[hash]
+	if !exists {
+		e, exists = mp.queued[hash]
+	}
+	if !exists {
+		return blockchain.Transaction{}, false
+	}
+	return e.tx, true
+}
+
+func (mp *Mempool) Has(hash string) bool {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+	return mp.has(hash)
+}
+
+func (mp *Mempool) has(hash string) bool {
+	_, pending := mp.pending[hash]
+	_, queued := mp.queued[hash]
+	return pending || queued
+}
+
+// insert puts e in the pending or the queued transactions. Must be called with mp.mutex held.
+func (mp *Mempool) insert(e *entry) error {
+	t := &e.tx
+	tip := mp.chain.GetLastBlock()
+	if t.Expired(tip.Index + 1) {
+		return ErrExpired
+	}
+	// a disconnected block can lock again the transactions that could follow it
+	if err := t.CheckLocks(tip.Index+1, tip.Timestamp); err != nil {
+		return fmt.Errorf("%w : %v", ErrLocked, err)
+	}
+	if mp.nonces && t.Nonce < mp.chain.GetAccount(t.Sender).Nonce {
+		return ErrNonceUsed
+	}
+	// outputs spent by a confirmed transaction make the ones of the mempool spending them invalid
+	if !mp.nonces {
+		if err := mp.chain.CheckInputs(t); err != nil {
+			return fmt.Errorf("%w : %v", ErrInputs, err)
+		}
+	}
+	if next := mp.nextNonce(t.Sender); !mp.nonces || t.Nonce == next {
+		mp.pending[t.Hash] = e
+	} else {
+		log.Printf("Queueing transaction %s ; nonce %d while %d is expected", t.Hash, t.Nonce, next)
+		mp.queued[t.Hash] = e
+	}
+	entries := mp.senders[t.Sender]
+	i := sort.Search(len(entries), func(i int) bool { return entries[i].tx.Nonce > t.Nonce })
+	entries = append(entries, nil)
+	copy(entries[i+1:], entries[i:])
+	entries[i] = e
+	mp.senders[t.Sender] = entries
+	mp.bytes += e.size
+	return nil
+}
+
+// remove drops the transaction with the given hash. Must be called with mp.mutex held.
+func (mp *Mempool) remove(hash string) *entry {
+	e, exists := mp.pending[hash]
+	if !exists {
+		if e, exists = mp.queued[hash]; !exists {
+			return nil
+		}
+	}
+	delete(mp.pending, hash)
+	delete(mp.queued, hash)
+	entries := mp.senders[e.tx.Sender]
+	for i := range entries {
+		if entries[i] == e {
+			entries = append(entries[:i], entries[i+1:]...)
+			break
+		}
+	}
+	if len(entries) == 0 {
+		delete(mp.senders, e.tx.Sender)
+	} else {
+		mp.senders[e.tx.Sender] = entries
+	}
+	mp.bytes -= e.size
+	return e
+}
+
+// nextNonce returns the nonce following the sender's pending transactions. Must be called with mp.mutex held.
+func (mp *Mempool) nextNonce(sender string) uint64 {
+	next := mp.chain.GetAccount(sender).Nonce
+	for _, e := range mp.senders[sender] {
+		if _, pending := mp.pending[e.tx.Hash]; pending && e.tx.Nonce == next {
+			next++
+		}
+	}
+	return next
+}
+
+// promote moves the queued transactions that became executable to the pending ones. Must be called with
+// mp.mutex held.
+func (mp *Mempool) promote() {
+	senders := make(map[string]bool)
+	for _, e := range mp.queued {
+		senders[e.tx.Sender] = true
+	}
+	for sender := range senders {
+		next := mp.chain.GetAccount(sender).Nonce
+		for _, e := range mp.senders[sender] {
+			if e.tx.Nonce < next {
+				continue
+			} else if e.tx.Nonce > next {
+				break
+			}
+			if _, queued := mp.queued[e.tx.Hash]; queued {
+				mp.pending[e.tx.Hash] = e
+				delete(mp.queued, e.tx.Hash)
+			}
+			next++
+		}
+	}
+}
+
+// bySender returns the transactions of sender in nonce order. Must be called with mp.mutex held.
+func (mp *Mempool) bySender(sender string) []*entry {
+	return append([]*entry(nil), mp.senders[sender]...)
+}
+
+// conflicts returns the transactions t would replace. Must be called with mp.mutex held.
+func (mp *Mempool) conflicts(t *blockchain.Transaction) []*entry {
+	spent := make(map[string]bool, len(t.Inputs))
+	for _, in := range t.Inputs {
+		spent[in.String()] = true
+	}
+	var conflicts []*entry
+	for _, txns := range []map[string]*entry{mp.pending, mp.queued} {
+		for _, e := range txns {
+			conflict := false
+			if mp.nonces {
+				conflict = e.tx.Sender == t.Sender && e.tx.Nonce == t.Nonce
+			}
+			for _, in := range e.tx.Inputs {
+				conflict = conflict || spent[in.String()]
+			}
+			if conflict {
+				conflicts = append(conflicts, e)
+			}
+		}
+	}
+	return conflicts
+}
+
+// affordable reports whether the sender's balance covers t along with its other transactions but the replaced
+// ones. Must be called with mp.mutex held.
+func (mp *Mempool) affordable(t *blockchain.Transaction, replaced map[string]bool) bool {
+	total, err := t.Cost()
+	for _, e := range mp.bySender(t.Sender) {
+		if err != nil {
+			return false
+		}
+		if !replaced[e.tx.Hash] {
+			total, err = addCost(total, &e.tx)
+		}
+	}
+	return err == nil && total <= mp.chain.GetAccount(t.Sender).Balance
+}
+
+// dropOvercommitted drops, for each sender, the transactions whose cost goes over its balance once added to the
+// cost of the sender's previous transactions. Must be called with mp.mutex held.
+func (mp *Mempool) dropOvercommitted() {
+	if !mp.nonces {
+		return
+	}
+	for sender := range mp.senders {
+		balance := mp.chain.GetAccount(sender).Balance
+		var total uint64
+		var err error
+		for _, e := range mp.bySender(sender) {
+			if total, err = addCost(total, &e.tx); err != nil || total > balance {
+				log.Printf("Dropping transaction %s from the mempool ; %v", e.tx.Hash, ErrOvercommit)
+				mp.remove(e.tx.Hash)
+				total = balance + 1
+			}
+		}
+	}
+	mp.demote()
+}
+
+func addCost(total uint64, t *blockchain.Transaction) (uint64, error) {
+	cost, err := t.Cost()
+	if err != nil {
+		return 0, err
+	}
+	sum := total + cost
+	if sum < total {
+		return 0, blockchain.ErrOverflow
+	}
+	return sum, nil
+}
+
+// expire drops the transactions older than the TTL and queues again the later transactions of their senders.
+// Must be called with mp.mutex held.
+func (mp *Mempool) expire(now time.Time) {
+	dropped := false
+	for _, entries := range []map[string]*entry{mp.pending, mp.queued} {
+		for hash, e := range entries {
+			if now.Sub(e.added) > mp.config.TTL {
+				log.Printf("Dropping transaction %s from the mempool ; older than %s", hash, mp.config.TTL)
+				mp.remove(hash)
+				dropped = true
+			}
+		}
+	}
+	if dropped {
+		mp.demote()
+	}
+}
+
+// enforceLimits evicts transactions until the mempool fits in its limits. Only the last transaction of
+// each sender can be evicted, so that the others stay executable ; the one with the lowest fee rate goes first.
+// It returns the evicted transactions. Must be called with mp.mutex held.
+func (mp *Mempool) enforceLimits() []*entry {
+	var evicted []*entry
+	for len(mp.pending)+len(mp.queued) > mp.config.MaxCount || mp.bytes > mp.config.MaxBytes {
+		var lowest *entry
+		for _, e := range mp.evictable() {
+			if lowest == nil || e.feeRate < lowest.feeRate ||
+				(e.feeRate == lowest.feeRate && e.added.After(lowest.added)) {
+				lowest = e
+			}
+		}
+		log.Printf("Evicting transaction %s from the full mempool", lowest.tx.Hash)
+		mp.remove(lowest.tx.Hash)
+		evicted = append(evicted, lowest)
+	}
+	return evicted
+}
+
+// restore puts back the entries removed while trying to add the transaction with the given hash, apart from
+// that transaction. Must be called with mp.mutex held.
+func (mp *Mempool) restore(entries []*entry, hash string) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].tx.Nonce < entries[j].tx.Nonce })
+	for _, e := range entries {
+		if e.tx.Hash == hash || mp.has(e.tx.Hash) {
+			continue
+		}
+		if err := mp.insert(e); err != nil {
+			log.Printf("Dropping transaction %s from the mempool ; %v", e.tx.Hash, err)
+		}
+	}
+	mp.promote()
+}
+
+// evictable returns the transactions that can be evicted without making others unexecutable : the last one
+// of each sender, or all of them when nonces are not used. Must be called with mp.mutex held.
+func (mp *Mempool) evictable() []*entry {
+	var entries []*entry
+	for _, senderEntries := range mp.senders {
+		if !mp.nonces {
+			entries = append(entries, senderEntries...)
+		} else {
+			entries = append(entries, senderEntries[len(senderEntries)-1])
+		}
+	}
+	return entries
+}
+
+// demote moves back to the queued transactions the pending ones whose nonce doesn't follow the sender's
+// other pending transactions anymore. Must be called with mp.mutex held.
+func (mp *Mempool) demote() {
+	if !mp.nonces {
+		return
+	}
+	for sender, entries := range mp.senders {
+		next := mp.chain.GetAccount(sender).Nonce
+		for _, e := range entries {
+			if _, pending := mp.pending[e.tx.Hash]; !pending {
+				continue
+			}
+			if e.tx.Nonce == next {
+				next++
+				continue
+			}
+			delete(mp.pending, e.tx.Hash)
+			mp.queued[e.tx.Hash] = e
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package mempool
+
+import (
+	"errors"
+	"ketcoin/src/blockchain"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// chain is a tip where every account holds 1000 coins.
+type chain struct {
+	tip    *blockchain.Block
+	nonces map[string]uint64
+}
+
+func newChain() *chain {
+	return &chain{tip: &blockchain.Block{Index: 10}, nonces: make(map[string]uint64)}
+}
+
+func (c *chain) GetAccount(addr string) blockchain.Account {
+	return blockchain.Account{Address: addr, Balance: 1000, Nonce: c.nonces[addr]}
+}
+
+func (c *chain) GetLastBlock() *blockchain.Block             { return c.tip }
+func (c *chain) CheckInputs(t *blockchain.Transaction) error { return nil }
+
+var testConfig = Config{MaxCount: 10, MaxBytes: 1 << 20, MaxPerSender: 3, TTL: time.Hour, FeeBump: 10}
+
+func tx(sender string, nonce, fee uint64) blockchain.Transaction {
+	t := blockchain.Transaction{Sender: sender, Nonce: nonce, Fee: fee, Outputs: []blockchain.Output{{Address: "bob", Amount: 1}}}
+	t.Hash = t.ComputeHash()
+	return t
+}
+
+func add(t *testing.T, mp *Mempool, txns ...blockchain.Transaction) {
+	t.Helper()
+	for _, tx := range txns {
+		if err := mp.Add(tx); err != nil {
+			t.Fatalf("adding transaction %d of %s : %v", tx.Nonce, tx.Sender, err)
+		}
+	}
+}
+
+func hashes(txns ...blockchain.Transaction) []string {
+	h := make([]string, len(txns))
+	for i, t := range txns {
+		h[i] = t.Hash
+	}
+	return h
+}
+
+func TestQueueingAndPromotion(t *testing.T) {
+	c := newChain()
+	mp := New(c, blockchain.RegtestParams, testConfig)
+	a0, a1, a2 := tx("alice", 0, 1), tx("alice", 1, 1), tx("alice", 2, 1)
+
+	add(t, mp, a2, a1)
+	if count, held := mp.Count(), len(mp.queued); count != 0 || held != 2 {
+		t.Fatalf("%d pending and %d queued transactions, expected 0 and 2", count, held)
+	}
+	add(t, mp, a0)
+	if got, want := hashes(mp.Pending()...), hashes(a0, a1, a2); !reflect.DeepEqual(got, want) {
+		t.Fatalf("pending %v, expected %v", got, want)
+	}
+
+	// a block confirming a0 leaves the others executable, and a1 being dropped queues a2 again
+	c.nonces["alice"] = 1
+	mp.Update([]*blockchain.Block{{Txns: []blockchain.Transaction{a0}}})
+	if got, want := hashes(mp.Pending()...), hashes(a1, a2); !reflect.DeepEqual(got, want) {
+		t.Fatalf("pending %v after the block, expected %v", got, want)
+	}
+	mp.mutex.Lock()
+	mp.remove(a1.Hash)
+	mp.demote()
+	mp.mutex.Unlock()
+	if mp.Count() != 0 || !mp.Has(a2.Hash) {
+		t.Errorf("transaction 2 not queued again once transaction 1 is gone")
+	}
+}
+
+func TestPendingOrder(t *testing.T) {
+	mp := New(newChain(), blockchain.RegtestParams, testConfig)
+	a0, a1, b0 := tx("alice", 0, 1), tx("alice", 1, 100), tx("bob", 0, 5)
+	add(t, mp, a1, b0, a0)
+
+	// a1 pays the most but must follow a0
+	if got, want := hashes(mp.Pending()...), hashes(b0, a0, a1); !reflect.DeepEqual(got, want) {
+		t.Errorf("pending %v, expected %v", got, want)
+	}
+}
+
+func TestSenderLimit(t *testing.T) {
+	mp := New(newChain(), blockchain.RegtestParams, testConfig)
+	add(t, mp, tx("alice", 0, 1), tx("alice", 1, 1), tx("alice", 2, 1))
+
+	if err := mp.Add(tx("alice", 3, 1)); !errors.Is(err, ErrSenderLimit) {
+		t.Errorf("fourth transaction returned %v, expected ErrSenderLimit", err)
+	}
+	// replacing one of them doesn't add to the count
+	add(t, mp, tx("alice", 2, 11), tx("bob", 0, 1))
+}
+
+func TestEvictionOrder(t *testing.T) {
+	config := testConfig
+	config.MaxCount = 3
+	mp := New(newChain(), blockchain.RegtestParams, config)
+	a0, a1, b0 := tx("alice", 0, 1), tx("alice", 1, 50), tx("bob", 0, 10)
+	add(t, mp, a0, a1, b0)
+
+	// a0 pays the least but a1 depends on it, so b0 is evicted
+	c0 := tx("carol", 0, 20)
+	add(t, mp, c0)
+	if mp.Has(b0.Hash) || !mp.Has(a0.Hash) || !mp.Has(a1.Hash) || !mp.Has(c0.Hash) {
+		t.Errorf("expected only bob's transaction to be evicted")
+	}
+
+	// a transaction paying less than everything evictable doesn't get in
+	if err := mp.Add(tx("dave", 0, 5)); !errors.Is(err, ErrFull) {
+		t.Errorf("low fee transaction returned %v, expected ErrFull", err)
+	}
+	if got, want := hashes(mp.Pending()...), hashes(c0, a0, a1); !reflect.DeepEqual(got, want) {
+		t.Errorf("pending %v, expected %v", got, want)
+	}
+}
+
+func TestReplacementFeeBump(t *testing.T) {
+	mp := New(newChain(), blockchain.RegtestParams, testConfig)
+	a0 := tx("alice", 0, 5)
+	add(t, mp, a0)
+
+	if err := mp.Add(tx("alice", 0, 14)); !errors.Is(err, ErrConflict) {
+		t.Errorf("replacement below the fee bump returned %v, expected ErrConflict", err)
+	}
+	replacement := tx("alice", 0, 15)
+	add(t, mp, replacement)
+	if mp.Has(a0.Hash) || !mp.Has(replacement.Hash) || mp.Count() != 1 {
+		t.Errorf("transaction not replaced")
+	}
+}
+
+func TestRestoreOnFailure(t *testing.T) {
+	c := newChain()
+	a0, b0 := tx("alice", 0, 5), tx("bob", 0, 100)
+
+	// the replacement can't be inserted
+	mp := New(c, blockchain.RegtestParams, testConfig)
+	add(t, mp, a0)
+	expired := tx("alice", 0, 50)
+	expired.ExpiryHeight = c.tip.Index
+	expired.Hash = expired.ComputeHash()
+	if err := mp.Add(expired); !errors.Is(err, ErrExpired) {
+		t.Errorf("expired replacement returned %v, expected ErrExpired", err)
+	}
+	if !mp.Has(a0.Hash) || mp.Count() != 1 {
+		t.Errorf("replaced transaction not restored after a failed insertion")
+	}
+
+	// the replacement is the first evicted
+	mp = New(c, blockchain.RegtestParams, Config{MaxCount: 10, MaxBytes: a0.Size() + b0.Size() + 50, MaxPerSender: 3, TTL: time.Hour, FeeBump: 10})
+	add(t, mp, a0, b0)
+	large := tx("alice", 0, 15)
+	large.Data = make([]byte, 500)
+	large.Hash = large.ComputeHash()
+	if err := mp.Add(large); !errors.Is(err, ErrFull) {
+		t.Errorf("large replacement returned %v, expected ErrFull", err)
+	}
+	if got, want := hashes(mp.Pending()...), hashes(b0, a0); !reflect.DeepEqual(got, want) {
+		t.Errorf("pending %v after a failed replacement, expected %v", got, want)
+	}
+}
+
+func TestLockedTransactions(t *testing.T) {
+	c := newChain()
+	mp := New(c, blockchain.RegtestParams, testConfig)
+	locked := tx("alice", 0, 1)
+	locked.LockHeight = c.tip.Index + 2
+	locked.Hash = locked.ComputeHash()
+	if err := mp.Add(locked); !errors.Is(err, ErrLocked) {
+		t.Errorf("locked transaction returned %v, expected ErrLocked", err)
+	}
+
+	// a transaction that could go in the block following the tip is locked again once that block is disconnected
+	unlocked := tx("bob", 0, 1)
+	unlocked.LockHeight = c.tip.Index + 1
+	unlocked.Hash = unlocked.ComputeHash()
+	add(t, mp, unlocked)
+	c.tip = &blockchain.Block{Index: c.tip.Index - 1}
+	mp.Update(nil)
+	if mp.Has(unlocked.Hash) {
+		t.Errorf("locked transaction kept after the tip moved back")
+	}
+}
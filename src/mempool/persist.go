@@ -0,0 +1,46 @@
+package mempool
+
+import (
+	"encoding/json"
+	"ketcoin/src/blockchain"
+	"os"
+	"sort"
+)
+
+// Save writes every transaction of the mempool, queued ones included, to the file at path.
+// The file is replaced atomically, so a crash leaves the previous one whole.
+func (mp *Mempool) Save(path string) error {
+	mp.mutex.RLock()
+	txns := make([]blockchain.Transaction, 0, len(mp.pending)+len(mp.queued))
+	for _, entries := range []map[string]*entry{mp.pending, mp.queued} {
+		for _, e := range entries {
+			txns = append(txns, e.tx)
+		}
+	}
+	mp.mutex.RUnlock()
+	// in nonce order so that loading them back doesn't queue them
+	sort.Slice(txns, func(i, j int) bool { return txns[i].Nonce < txns[j].Nonce })
+
+	data, err := json.Marshal(txns)
+	if err != nil {
+		return err
+	}
+	return blockchain.WriteFileAtomic(path, data)
+}
+
+// Load reads the transactions saved to the file at path. A missing file holds no transactions.
+// They must be validated against the tip again before being added.
+func Load(path string) ([]blockchain.Transaction, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var txns []blockchain.Transaction
+	if err := json.Unmarshal(data, &txns); err != nil {
+		return nil, err
+	}
+	return txns, nil
+}
@@ -0,0 +1,166 @@
+// Package miner searches for the proof of work of new blocks.
+package miner
+
+import (
+	"context"
+	"ketcoin/src/blockchain"
+	"ketcoin/src/consensus"
+	"log"
+	"sync"
+	"time"
+)
+
+// Miner runs worker goroutines sealing the block template with the consensus engine.
+// The work is dropped as soon as Refresh is called, so that a new tip or a better template is mined right away.
+// It is safe for concurrent use.
+type Miner struct {
+	control  sync.Mutex // serializes Start and Stop
+	mutex    sync.Mutex
+	backend  consensus.Backend
+	engine   consensus.Engine
+	threads  int
+	cancel   context.CancelFunc // stops the mining loop, nil when stopped
+	refresh  chan struct{}
+	hashes   uint64 // counted by the engine when started
+	started  time.Time
+	stopping sync.WaitGroup
+}
+
+func New(backend consensus.Backend, engine consensus.Engine, threads int) *Miner {
+	if threads < 1 {
+		threads = 1
+	}
+	return &Miner{
+		backend: backend,
+		engine:  engine,
+		threads: threads,
+		refresh: make(chan struct{}, 1),
+	}
+}
+
+// Start starts mining with the given number of worker goroutines, restarting if already mining.
+// threads below 1 keep the current number.
+func (m *Miner) Start(threads int) {
+	m.control.Lock()
+	defer m.control.Unlock()
+	m.stop()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if threads > 0 {
+		m.threads = threads
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.started = time.Now()
+	m.hashes = m.hashCount()
+	m.stopping.Add(1)
+	go m.loop(ctx, m.threads)
+	log.Printf("Mining started with %d threads", m.threads)
+}
+
+// Stop stops mining and waits for the workers to return.
+func (m *Miner) Stop() {
+	m.control.Lock()
+	defer m.control.Unlock()
+	m.stop()
+}
+
+func (m *Miner) stop() {
+	m.mutex.Lock()
+	cancel := m.cancel
+	m.cancel = nil
+	m.mutex.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	m.stopping.Wait()
+	log.Println("Mining stopped")
+}
+
+// Refresh drops the current work for a new template.
+func (m *Miner) Refresh() {
+	select {
+	case m.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Running reports whether the miner is started.
+func (m *Miner) Running() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.cancel != nil
+}
+
+// Threads returns the number of worker goroutines.
+func (m *Miner) Threads() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.threads
+}
+
+// Hashrate returns the hashes per second tried since the miner was started, 0 when stopped or when the engine
+// doesn't count hashes.
+func (m *Miner) Hashrate() float64 {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.cancel == nil {
+		return 0
+	}
+	return float64(m.hashCount()-m.hashes) / time.Since(m.started).Seconds()
+}
+
+func (m *Miner) hashCount() uint64 {
+	if counter, ok := m.engine.(consensus.HashCounter); ok {
+		return counter.Hashes()
+	}
+	return 0
+}
+
+// loop mines templates until ctx is cancelled.
+func (m *Miner) loop(ctx context.Context, threads int) {
+	defer m.stopping.Done()
+	for ctx.Err() == nil {
+		// a refresh asked before the template is built is answered by it
+		select {
+		case <-m.refresh:
+		default:
+		}
+		b := m.backend.BlockTemplate()
+		if b == nil {
+			select {
+			case <-ctx.Done():
+			case <-m.refresh:
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		work, cancel := context.WithCancel(ctx)
+		found := make(chan *blockchain.Block, threads)
+		var workers sync.WaitGroup
+		for i := 0; i < threads; i++ {
+			workers.Add(1)
+			go func(worker int) {
+				defer workers.Done()
+				if sealed, err := m.engine.Seal(work, b, worker, threads); err == nil {
+					found <- sealed
+				}
+			}(i)
+		}
+		select {
+		case solved := <-found:
+			cancel()
+			workers.Wait()
+			log.Printf("Found good nonce for block %d!", solved.Index)
+			m.backend.SubmitBlock(solved)
+		case <-m.refresh:
+			cancel()
+			workers.Wait()
+		case <-ctx.Done():
+			cancel()
+			workers.Wait()
+		}
+	}
+}
@@ -2,32 +2,114 @@ package p2p
 
 import (
 	"encoding/json"
+	"errors"
 	"ketcoin/src/blockchain"
+	"ketcoin/src/consensus"
+	"ketcoin/src/pool"
 	"log"
 	"net"
+	"time"
 )
 
-func (n *Node) transactionRequestHandler(JSON []byte) {
+// transactionRequestHandler handles a transaction sent by conn, or created locally when conn is nil.
+func (n *Node) transactionRequestHandler(conn net.Conn, JSON []byte) {
 	txn := &blockchain.Transaction{}
 
 	err := json.Unmarshal(JSON, txn)
 	if err != nil {
 		log.Println("Error while decoding transaction")
 		log.Println(err)
+		return
+	}
+	if conn != nil {
+		n.knownBy(conn).Add(txn.Hash)
+	}
+	if n.rejected.Has(txn.Hash) || n.mempool.Has(txn.Hash) {
+		n.received(txn.Hash)
+		log.Printf("Transaction %s already handled, ignoring...", txn.Hash)
+		return
 	}
 
-	if n.validateTransaction(txn) {
+	err = n.validateTransaction(txn)
+	if errors.Is(err, errInvalidSignature) {
+		// another announcer may still send the genuine transaction once the request times out
+		log.Println("Transaction not authentic, ignoring...")
+		return
+	}
+	n.received(txn.Hash)
+	if err == nil {
 		log.Println("Transaction validated, adding it to the mempool")
-		n.mutex.Lock()
-		n.mempool[txn.Hash[:]] = *txn
-		n.mutex.Unlock()
+		n.addToMempool(txn)
 	} else {
-		log.Println("Transaction invalid : insufficient balance or invalid signature. Ignoring...")
+		log.Println("Transaction invalid : insufficient balance, used nonce or unmet fee. Ignoring...")
+		n.rejected.Add(txn.Hash)
 	}
 
 }
 
-func (n *Node) blockReceptionHandler(JSON []byte) {
+// invHandler asks conn for the announced transactions that are neither known nor already requested.
+func (n *Node) invHandler(conn net.Conn, JSON []byte) {
+	inv := &Inventory{}
+	err := json.Unmarshal(JSON, inv)
+	if err != nil {
+		log.Println("Error while decoding inv")
+		log.Println(err)
+		return
+	}
+	if len(inv.Hashes) > MAX_INV_SIZE {
+		log.Printf("Peer %s sent a inv of %d hashes, ignoring...", conn.RemoteAddr(), len(inv.Hashes))
+		return
+	}
+
+	known := n.knownBy(conn)
+	request := &Inventory{}
+	now := time.Now()
+	for _, hash := range inv.Hashes {
+		known.Add(hash)
+		if n.wanted(hash, conn, now) {
+			request.Hashes = append(request.Hashes, hash)
+		}
+	}
+	if len(request.Hashes) > 0 {
+		n.sendHashes(conn, "getdata", request)
+	}
+}
+
+// getDataHandler sends conn the requested transactions held in the mempool.
+func (n *Node) getDataHandler(conn net.Conn, JSON []byte) {
+	request := &Inventory{}
+	err := json.Unmarshal(JSON, request)
+	if err != nil {
+		log.Println("Error while decoding getdata")
+		log.Println(err)
+		return
+	}
+	if len(request.Hashes) > MAX_INV_SIZE {
+		log.Printf("Peer %s sent a getdata of %d hashes, ignoring...", conn.RemoteAddr(), len(request.Hashes))
+		return
+	}
+
+	known := n.knownBy(conn)
+	for _, hash := range request.Hashes {
+		t, exists := n.mempool.Get(hash)
+		if !exists {
+			continue
+		}
+		transactionData, err := json.Marshal(t)
+		if err != nil {
+			log.Println("Error encoding transaction data")
+			log.Println(err)
+			continue
+		}
+		known.Add(hash)
+		n.send(conn, &Message{
+			Rpc:  "transactionrequest",
+			JSON: transactionData,
+		})
+	}
+}
+
+func (n *Node) blockReceptionHandler(conn net.Conn, JSON []byte) {
 	block := &blockchain.Block{}
 	err := json.Unmarshal(JSON, block)
 	if err != nil {
@@ -35,7 +117,7 @@ func (n *Node) blockReceptionHandler(JSON []byte) {
 		log.Println(err)
 	}
 
-	n.validateBlock(block)
+	n.validateBlock(block, conn)
 }
 
 func (n *Node) blockchainReceptionHandler(JSON []byte) {
@@ -63,3 +145,180 @@ func (n *Node) blockchainRequestHandler(conn net.Conn) {
 
 	n.send(conn, msg)
 }
+
+func (n *Node) versionHandler(conn net.Conn, JSON []byte) {
+	version := &Version{}
+	err := json.Unmarshal(JSON, version)
+	if err != nil {
+		log.Println("Error while decoding version")
+		log.Println(err)
+	}
+
+	if version.Magic != n.params.Magic || version.GenesisHash != n.params.GenesisHash {
+		log.Printf("Peer %s is on another network (magic %x, genesis %s), disconnecting...", conn.RemoteAddr(), version.Magic, version.GenesisHash)
+		n.removePeer(conn)
+		return
+	}
+
+	if isValid, _ := n.peers.Load(conn); isValid != true {
+		log.Printf("Adding %s as a peer", conn.RemoteAddr())
+		n.peers.Store(conn, true)
+		n.sendVersion(conn)
+		hashes := make([]string, 0)
+		for _, t := range n.mempool.Pending() {
+			hashes = append(hashes, t.Hash)
+		}
+		n.sendInventory(conn, hashes)
+	}
+}
+
+func (n *Node) supplyRequestHandler(conn net.Conn) {
+	index, supply := n.blockchain.GetSupply()
+	supplyData, err := json.Marshal(&SupplyInfo{
+		Index:       index,
+		Circulating: supply,
+		Max:         n.params.MaxSupply,
+	})
+	if err != nil {
+		log.Println("Error while encoding supply")
+		log.Println(err)
+		return
+	}
+	n.send(conn, &Message{
+		Rpc:  "supplyreception",
+		JSON: supplyData,
+	})
+}
+
+func (n *Node) memoRequestHandler(conn net.Conn, JSON []byte) {
+	request := &MemoRequest{}
+	err := json.Unmarshal(JSON, request)
+	if err != nil {
+		log.Println("Error while decoding memo request")
+		log.Println(err)
+		return
+	}
+	if request.Limit <= 0 || request.Limit > MAX_MEMO_RESULTS {
+		request.Limit = MAX_MEMO_RESULTS
+	}
+
+	memoData, err := json.Marshal(n.blockchain.FindMemos(request.Prefix, request.Limit))
+	if err != nil {
+		log.Println("Error while encoding memos")
+		log.Println(err)
+		return
+	}
+	n.send(conn, &Message{
+		Rpc:  "memoreception",
+		JSON: memoData,
+	})
+}
+
+func (n *Node) minerRequestHandler(conn net.Conn, JSON []byte) {
+	if !isLocal(conn) {
+		log.Printf("Ignoring minerrequest from remote peer %s", conn.RemoteAddr())
+		return
+	}
+	request := &MinerRequest{}
+	err := json.Unmarshal(JSON, request)
+	if err != nil {
+		log.Println("Error while decoding miner request")
+		log.Println(err)
+		return
+	}
+	switch request.Action {
+	case "start":
+		n.miner.Start(request.Threads)
+	case "stop":
+		n.miner.Stop()
+	case "":
+	default:
+		log.Printf("Unknown miner action %s, ignoring...", request.Action)
+	}
+
+	statusData, err := json.Marshal(&MinerStatus{
+		Running:  n.miner.Running(),
+		Threads:  n.miner.Threads(),
+		Hashrate: n.miner.Hashrate(),
+	})
+	if err != nil {
+		log.Println("Error while encoding miner status")
+		log.Println(err)
+		return
+	}
+	n.send(conn, &Message{
+		Rpc:  "minerreception",
+		JSON: statusData,
+	})
+}
+
+func (n *Node) blockTemplateRequestHandler(conn net.Conn) {
+	if !isLocal(conn) {
+		log.Printf("Ignoring getblocktemplate from remote peer %s", conn.RemoteAddr())
+		return
+	}
+	template := &BlockTemplate{Block: n.generateBlock()}
+	if targeter, ok := n.engine.(consensus.Targeter); ok {
+		template.Target = targeter.Target(template.Block)
+	}
+	templateData, err := json.Marshal(template)
+	if err != nil {
+		log.Println("Error while encoding block template")
+		log.Println(err)
+		return
+	}
+	n.send(conn, &Message{
+		Rpc:  "blocktemplatereception",
+		JSON: templateData,
+	})
+}
+
+func (n *Node) submitBlockHandler(conn net.Conn, JSON []byte) {
+	if !isLocal(conn) {
+		log.Printf("Ignoring submitblock from remote peer %s", conn.RemoteAddr())
+		return
+	}
+	block := &blockchain.Block{}
+	err := json.Unmarshal(JSON, block)
+	if err != nil {
+		log.Println("Error while decoding submitted block")
+		log.Println(err)
+		return
+	}
+
+	log.Printf("Received block %s from external miner %s", block.Hash, conn.RemoteAddr())
+	resultData, err := json.Marshal(&SubmitResult{
+		Hash:     block.Hash,
+		Accepted: n.SubmitBlock(block),
+	})
+	if err != nil {
+		log.Println("Error while encoding submit result")
+		log.Println(err)
+		return
+	}
+	n.send(conn, &Message{
+		Rpc:  "submitblockreception",
+		JSON: resultData,
+	})
+}
+
+func (n *Node) poolRequestHandler(conn net.Conn) {
+	if !isLocal(conn) {
+		log.Printf("Ignoring poolrequest from remote peer %s", conn.RemoteAddr())
+		return
+	}
+	stats := make(map[string]pool.WorkerStats)
+	if p := n.getPool(); p != nil {
+		stats = p.Stats()
+	}
+	statsData, err := json.Marshal(stats)
+	if err != nil {
+		log.Println("Error while encoding pool statistics")
+		log.Println(err)
+		return
+	}
+	n.send(conn, &Message{
+		Rpc:  "poolreception",
+		JSON: statsData,
+	})
+}
@@ -3,18 +3,32 @@ package p2p
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"ketcoin/src/blockchain"
+	"ketcoin/src/consensus"
 	"ketcoin/src/crypto"
+	"ketcoin/src/mempool"
+	"ketcoin/src/miner"
+	"ketcoin/src/pool"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
 
 const DIALTIMEOUT = time.Second * 5
 
+var errInvalidSignature = errors.New("hash or signature not matching the content")
+
+// statusInterval is the time between two logs of the node state.
+const statusInterval = time.Second * 10
+
+// mempoolFile holds the mempool in the data directory between runs.
+const mempoolFile = "mempool.json"
+
 type Node struct {
 	mutex       sync.RWMutex
 	listenPort  uint16
@@ -24,7 +38,25 @@ type Node struct {
 	blockchain  *blockchain.Blockchain
 	account     *blockchain.Account
 	sigTree     *crypto.MerkleSigTree
-	mempool     map[string]blockchain.Transaction
+	mempool     *mempool.Mempool
+	miner       *miner.Miner
+	pool        *pool.Server // nil unless StartPool was called, guarded by mutex
+	mining      MiningConfig
+	params      *blockchain.ChainParams
+	engine      consensus.Engine
+	dataDir     string
+	known       sync.Map            // net.Conn → *hashCache of the transactions the peer has
+	rejected    *hashCache          // transactions not accepted in the mempool since the last block
+	requested   map[string]*request // transactions asked for with getdata, guarded by mutex
+	asking      map[net.Conn]int    // number of requested transactions each peer was asked for, guarded by mutex
+}
+
+// MiningConfig sets what the node mines. The miner can still be started with a minerrequest when disabled.
+type MiningConfig struct {
+	Enabled     bool
+	Threads     int
+	EmptyBlocks bool          // mine blocks with only a coinbase when the mempool is empty
+	MinInterval time.Duration // since the tip before mining on it
 }
 
 type Message struct {
@@ -32,72 +64,172 @@ type Message struct {
 	JSON []byte
 }
 
-func MakeNode(port uint16) *Node {
-	return &Node{
+// SupplyInfo answers a supplyrequest.
+type SupplyInfo struct {
+	Index       uint64 // index of the tip the supply was measured at
+	Circulating uint64
+	Max         uint64 // 0 when the supply is uncapped
+}
+
+// MemoRequest asks for the transactions whose data starts with Prefix, answered with a list of
+// blockchain.MemoEntry.
+type MemoRequest struct {
+	Prefix []byte
+	Limit  int // at most MAX_MEMO_RESULTS
+}
+
+// MAX_MEMO_RESULTS bounds the number of transactions answering a memorequest.
+const MAX_MEMO_RESULTS = 100
+
+// MinerRequest starts ("start") or stops ("stop") the miner of the node, or just asks for its status (""),
+// answered with a MinerStatus. It is only accepted from the loopback interface.
+type MinerRequest struct {
+	Action  string
+	Threads int // worker goroutines to start, 0 keeps the current number
+}
+
+// MinerStatus answers a minerrequest.
+type MinerStatus struct {
+	Running  bool
+	Threads  int
+	Hashrate float64 // hashes per second since the miner was started
+}
+
+// BlockTemplate answers a getblocktemplate. External miners look for a Block.Nonce giving a Block.ComputeHash
+// starting with Target, set Block.Hash and send the Block back with a submitblock, answered with a SubmitResult.
+// Both are only accepted from the loopback interface. Target is empty when the consensus engine doesn't seal
+// blocks with a hash.
+type BlockTemplate struct {
+	Block  *blockchain.Block // on top of the tip, paying the node's account
+	Target string
+}
+
+// SubmitResult answers a submitblock.
+type SubmitResult struct {
+	Hash     string
+	Accepted bool
+}
+
+// A poolrequest asks for the statistics of the pool workers, answered with a map of pool.WorkerStats by worker
+// name. It is only accepted from the loopback interface.
+
+// Inventory announces transactions by hash with an inv message, or asks for them with a getdata message,
+// answered with a transactionrequest per transaction.
+type Inventory struct {
+	Hashes []string // at most MAX_INV_SIZE
+}
+
+// Version is the first message exchanged with a peer. Peers on another network are disconnected.
+type Version struct {
+	Magic       uint32
+	GenesisHash string
+}
+
+// MakeNode returns a node mining as configured once started.
+func MakeNode(port uint16, params *blockchain.ChainParams, mining MiningConfig) *Node {
+	engine, err := consensus.Get(params)
+	if err != nil {
+		log.Fatal(err)
+	}
+	n := &Node{
 		listenPort: port,
 		blockchain: new(blockchain.Blockchain),
+		params:     params,
+		mining:     mining,
+		engine:     engine,
+		rejected:   newHashCache(REJECT_CACHE_SIZE),
+		requested:  make(map[string]*request),
+		asking:     make(map[net.Conn]int),
 	}
+	n.miner = miner.New(n, engine, mining.Threads)
+	return n
 }
 
 func (n *Node) Start() {
-	go n.mine()
+	if n.mining.Enabled {
+		n.miner.Start(0)
+	} else {
+		log.Println("Mining disabled, only relaying blocks and transactions")
+	}
+	go n.reportState()
 	for {
 		go n.handle(<-n.connections)
 	}
 }
 
-func (n *Node) validateTransaction(t *blockchain.Transaction) bool {
-	var valid bool
-	if acc, exists := n.blockchain.Accounts[t.Sender]; exists {
-		valid = acc.Balance-t.Amount >= 0
-		if acc.Balance-t.Amount < 0 {
-			log.Printf("Invalid transaction ; insufficient balance")
-		}
-	} else {
-		acc = &blockchain.Account{
-			Address: t.Sender,
-			Balance: 0,
-		}
-		n.blockchain.Accounts[t.Sender] = acc
-		valid = false
-	}
-	if valid {
-		addr := make([]byte, 32)
-		hex.Decode(addr, []byte(t.Sender))
-		hash := make([]byte, 32)
-		hex.Decode(hash, []byte(t.Hash))
-
-		valid = crypto.Verify(t.Signature, *(*[32]byte)(addr), *(*[32]byte)(hash))
-		if !valid {
-			log.Printf("Invalid transaction ; incorrect signature")
-		}
+// validateTransaction checks t against the tip. It returns errInvalidSignature when the hash or signature of t
+// don't match its content, which says nothing about other transactions carrying the same hash.
+func (n *Node) validateTransaction(t *blockchain.Transaction) error {
+	err := n.checkTransaction(t)
+	if err != nil {
+		log.Printf("Invalid transaction ; %v", err)
 	}
-	log.Println(n.blockchain.Accounts[t.Sender])
-	return valid
+	return err
 }
 
-func (n *Node) getTransactionList() []blockchain.Transaction {
-	n.mutex.RLock()
-	defer n.mutex.RUnlock()
+func (n *Node) checkTransaction(t *blockchain.Transaction) error {
+	// checked first so that the other errors are about the content the hash commits to
+	if t.Hash != t.ComputeHash() {
+		return errInvalidSignature
+	}
+	if err := t.CheckOutputs(); err != nil {
+		return err
+	}
+	if err := t.CheckData(n.params); err != nil {
+		return err
+	}
+	acc := n.blockchain.GetAccount(t.Sender)
+	if cost, err := t.Cost(); err != nil || acc.Balance < cost {
+		return errors.New("insufficient balance")
+	}
+	if minFee := n.params.MinTxFee + n.params.DataFee(t); t.Fee < minFee {
+		return fmt.Errorf("fee %d below minimum of %d", t.Fee, minFee)
+	}
+	// only transactions that can go in the next block are relayed
+	if tip := n.blockchain.GetLastBlock(); t.Expired(tip.Index+1) {
+		return fmt.Errorf("expired at index %d", t.ExpiryHeight)
+	} else if err := t.CheckLocks(tip.Index+1, tip.Timestamp); err != nil {
+		return err
+	}
+	if t.Nonce < acc.Nonce {
+		return fmt.Errorf("nonce %d already used", t.Nonce)
+	}
+	if !t.VerifySignature() {
+		return errInvalidSignature
+	}
+	return nil
+}
 
-	txns := make([]blockchain.Transaction, 0, len(n.mempool))
-	for _, val := range n.mempool {
-		txns = append(txns, val)
+// addToMempool puts t in the mempool if it follows the sender's last pending nonce, and queues it otherwise.
+// t is then announced to the peers.
+func (n *Node) addToMempool(t *blockchain.Transaction) {
+	if err := n.mempool.Add(*t); err != nil {
+		log.Printf("Transaction %s not added to the mempool", t.Hash)
+		log.Println(err)
+		if !errors.Is(err, mempool.ErrKnown) {
+			n.rejected.Add(t.Hash)
+		}
+		return
 	}
-	return txns
+	n.announce(t.Hash)
+	n.refreshWork()
 }
 
 func (n *Node) generateBlock() *blockchain.Block {
-	n.blockchain.RLock()
-	defer n.blockchain.RUnlock()
+	last := n.blockchain.GetLastBlock()
 	b := &blockchain.Block{
-		Index:        n.blockchain.GetLastBlock().Index + 1,
-		PrevHash:     n.blockchain.GetLastBlock().Hash,
-		Timestamp:    time.Now(),
-		Txns:         n.getTransactionList(),
-		Nonce:        0,
-		Reward:       blockchain.BLOCK_REWARD,
-		MinerAddress: n.account.Address,
+		Index:     last.Index + 1,
+		PrevHash:  last.Hash,
+		Timestamp: time.Now(),
+	}
+	if err := n.engine.Prepare(n.blockchain, b, n.params); err != nil {
+		log.Println("Error preparing header of generated block")
+		log.Println(err)
+	}
+	b.Txns = n.blockchain.FilterTxns(n.mempool.Pending())
+	if err := n.engine.Finalize(n.blockchain, b, n.params, n.account.Address); err != nil {
+		log.Println("Error finalizing generated block")
+		log.Println(err)
 	}
 
 	return b
@@ -126,76 +258,136 @@ func (n *Node) broadcastBlock(b *blockchain.Block) {
 }
 
 func (n *Node) printState() {
-	for _, acc := range n.blockchain.Accounts {
-		log.Println(acc)
+	index, supply := n.blockchain.GetSupply()
+	log.Printf("Tip at index %d with %d coins in circulation", index, supply)
+	if n.miner.Running() {
+		log.Printf("Mining with %d threads at %.0f H/s, %d transactions pending", n.miner.Threads(), n.miner.Hashrate(), n.mempool.Count())
 	}
+	log.Println(n.blockchain.GetAccount(n.account.Address))
 }
 
-func (n *Node) mine() {
-	log.Println("currently mining")
-	for {
-		sleepCounter := 0
-		for len(n.mempool) == 0 {
-			time.Sleep(time.Second)
-			log.Println("mempool empty")
-			sleepCounter++
-			if (sleepCounter % 5) == 0 {
-				n.printState()
-			}
-		}
+// BlockTemplate returns the block to mine on top of the tip, or nil while the tip is younger than the minimum
+// block interval or, unless empty blocks are mined, while the mempool is empty.
+func (n *Node) BlockTemplate() *blockchain.Block {
+	if time.Since(n.blockchain.GetLastBlock().Timestamp) < n.mining.MinInterval {
+		return nil
+	}
+	if n.mempool.Count() == 0 && !n.mining.EmptyBlocks {
+		return nil
+	}
+	return n.generateBlock()
+}
 
-		txnNb := len(n.mempool)
-		var b *blockchain.Block
-		for {
-			b = n.generateBlock()
-			for txnNb >= len(n.mempool) && b.Index > n.blockchain.GetLastBlock().Index {
-				if b.ComputeHash()[0:1] != "0" {
-					fmt.Printf("H : %s\n", b.ComputeHash())
-					b.Nonce++
-				} else {
-					b.Hash = b.ComputeHash()
-					break
-				}
-			}
-			if b.ComputeHash()[0:1] == "0" {
-				break
-			}
-			txnNb = len(n.mempool)
-		}
+// SubmitBlock adds a mined block to the blockchain and broadcasts it, reporting whether it was accepted.
+func (n *Node) SubmitBlock(b *blockchain.Block) bool {
+	if !n.processBlock(b) {
+		return false
+	}
+	n.broadcastBlock(b)
+	return true
+}
 
-		log.Printf("Found good nonce for block!")
-		n.blockchain.Lock()
-		n.execute(b)
-		b.StateRoot = n.blockchain.GetStateRoot()
-		n.broadcastBlock(b)
-		n.blockchain.Unlock()
-		n.blockchain.AddBlock(b)
+// refreshWork has the miner and the pool workers switch to a new block template.
+func (n *Node) refreshWork() {
+	n.miner.Refresh()
+	if p := n.getPool(); p != nil {
+		p.Refresh()
+	}
+}
+
+// StartPool serves mining work to pool workers on the given port, counting shares with shareDifficulty
+// leading zero hex digits.
+func (n *Node) StartPool(port uint16, shareDifficulty int) error {
+	targeter, ok := n.engine.(consensus.Targeter)
+	if !ok {
+		return fmt.Errorf("consensus engine %q has no target for pool workers", n.params.Consensus)
+	}
+	p := pool.New(n, targeter, shareDifficulty)
+	if err := p.Listen(fmt.Sprintf(":%d", port)); err != nil {
+		return err
+	}
+	n.mutex.Lock()
+	n.pool = p
+	n.mutex.Unlock()
+	return nil
+}
+
+// getPool returns the pool server, nil unless StartPool was called.
+func (n *Node) getPool() *pool.Server {
+	n.mutex.RLock()
+	defer n.mutex.RUnlock()
+	return n.pool
+}
+
+// isLocal reports whether conn comes from the loopback interface, to which node control RPCs are restricted.
+func isLocal(conn net.Conn) bool {
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	return ok && addr.IP.IsLoopback()
+}
+
+// reportState logs the state of the node every statusInterval.
+func (n *Node) reportState() {
+	for range time.Tick(statusInterval) {
+		n.printState()
 	}
 }
 
 func (n *Node) handle(conn net.Conn) {
+	decoder := json.NewDecoder(conn)
 	for {
 		m := new(Message)
-		err := json.NewDecoder(conn).Decode(m)
+		err := decoder.Decode(m)
 		if err != nil {
 			log.Printf("Error decoding message from %s, closing connection", conn.RemoteAddr())
 			log.Println(err)
-			conn.Close()
+			n.removePeer(conn)
 			break
 		}
 
 		log.Printf("Received RPC : %s\nwith data : %s\nfrom : %s", m.Rpc, m.JSON, conn.RemoteAddr())
-		log.Printf("Adding %s as a peer", conn.RemoteAddr()) //can know if this peer was already in peer list
-		n.peers.LoadOrStore(conn, true)
+		// peers are only valid once they proved they are on the same network
+		isValid, _ := n.peers.LoadOrStore(conn, false)
+		if m.Rpc == "version" {
+			n.versionHandler(conn, m.JSON)
+			continue
+		}
+		if !isValid.(bool) {
+			log.Printf("Ignoring RPC %s from %s until it sends its version", m.Rpc, conn.RemoteAddr())
+			continue
+		}
 		switch m.Rpc {
 		case "blockchainrequest":
 			n.blockchainRequestHandler(conn)
 		case "blockchainreception":
 			n.blockchainReceptionHandler(m.JSON)
 		case "blockreception":
-			n.blockReceptionHandler(m.JSON)
+			n.blockReceptionHandler(conn, m.JSON)
 		case "transactionrequest":
-			n.transactionRequestHandler(m.JSON)
+			n.transactionRequestHandler(conn, m.JSON)
+		case "inv":
+			n.invHandler(conn, m.JSON)
+		case "getdata":
+			n.getDataHandler(conn, m.JSON)
+		case "supplyrequest":
+			n.supplyRequestHandler(conn)
+		case "supplyreception":
+			log.Printf("Peer %s reports supply %s", conn.RemoteAddr(), m.JSON)
+		case "memorequest":
+			n.memoRequestHandler(conn, m.JSON)
+		case "memoreception":
+			log.Printf("Peer %s reports memos %s", conn.RemoteAddr(), m.JSON)
+		case "minerrequest":
+			n.minerRequestHandler(conn, m.JSON)
+		case "minerreception":
+			log.Printf("Peer %s reports miner status %s", conn.RemoteAddr(), m.JSON)
+		case "getblocktemplate":
+			n.blockTemplateRequestHandler(conn)
+		case "submitblock":
+			n.submitBlockHandler(conn, m.JSON)
+		case "poolrequest":
+			n.poolRequestHandler(conn)
+		case "poolreception":
+			log.Printf("Peer %s reports pool workers %s", conn.RemoteAddr(), m.JSON)
 		default:
 			log.Printf("Remote procedure call %s does not exist on this client, ignoring...", m.Rpc)
 		}
@@ -211,87 +403,81 @@ func (n *Node) send(conn net.Conn, m *Message) {
 	}
 }
 
-func (n *Node) execute(b *blockchain.Block) {
-	for _, t := range b.Txns {
-		if acc, exists := n.blockchain.Accounts[t.Sender]; exists {
-			if acc.Balance >= t.Amount {
-				acc.Balance -= t.Amount
-			} else {
-				acc.Balance = 0
-			}
-		} else {
-			acc = &blockchain.Account{
-				Address: t.Receiver,
-				Balance: 0,
-			}
-			n.blockchain.Accounts[t.Receiver] = acc
-		}
+// processBlock adds b to the blockchain and updates the mempool.
+func (n *Node) processBlock(b *blockchain.Block) bool {
+	connected, disconnected, err := n.blockchain.AddBlock(b)
+	if err != nil {
+		log.Println("Block not added to the blockchain")
+		log.Println(err)
+		return false
+	}
+	n.updateMempool(connected, disconnected)
+	return true
+}
 
-		if acc, exists := n.blockchain.Accounts[t.Receiver]; exists {
-			acc.Balance += t.Amount
-		} else {
-			acc = &blockchain.Account{
-				Address: t.Receiver,
-				Balance: t.Amount,
-			}
-			n.blockchain.Accounts[t.Receiver] = acc
+// updateMempool drops the transactions confirmed by connected from the mempool and puts back the ones of
+// disconnected that are still valid on the new main chain, so that a chain switch doesn't lose them.
+func (n *Node) updateMempool(connected, disconnected []*blockchain.Block) {
+	n.mempool.Update(connected)
+	n.refreshWork()
+	// work on a new tip is only handed out once the minimum block interval has passed
+	if wait := n.mining.MinInterval - time.Since(n.blockchain.GetLastBlock().Timestamp); len(connected) > 0 && wait > 0 {
+		time.AfterFunc(wait, n.refreshWork)
+	}
+	// rejected transactions may be valid on the new tip
+	n.rejected.Reset()
+	confirmed := make(map[string]bool)
+	for _, b := range connected {
+		for _, t := range b.Txns {
+			confirmed[t.Hash] = true
 		}
-		//safe?
-		delete(n.mempool, t.Hash[:])
 	}
-
-	if acc, exists := n.blockchain.Accounts[b.MinerAddress]; exists {
-		acc.Balance += blockchain.BLOCK_REWARD
-	} else {
-		acc = &blockchain.Account{
-			Address: b.MinerAddress,
-			Balance: blockchain.BLOCK_REWARD,
+	// disconnected starts at the old tip, its transactions are put back oldest first to keep nonces in order
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, t := range disconnected[i].Txns {
+			if t.IsCoinbase() || confirmed[t.Hash] || n.mempool.Has(t.Hash) {
+				continue
+			}
+			if n.validateTransaction(&t) == nil {
+				log.Printf("Putting back transaction %s from disconnected block %s", t.Hash, disconnected[i].Hash)
+				n.addToMempool(&t)
+			}
 		}
-		n.blockchain.Accounts[b.MinerAddress] = acc
 	}
 }
 
-func (n *Node) validateBlock(b *blockchain.Block) {
-	if b.ComputeHash()[0:1] == "0" && b.Index == n.blockchain.GetLastBlock().Index+1 {
-		log.Println("Received block validated, executing transactions...")
-		n.execute(b)
-
-		if n.blockchain.GetStateRoot() != b.StateRoot {
-			log.Println("Printing state : ")
-			n.printState()
-
-			log.Printf("local SR after execution : \n%s\nBlock SR : \n%s", n.blockchain.GetStateRoot(), b.StateRoot)
-			log.Println("State roots don't match, requesting bc...")
-
-			n.peers.Range(func(key, value interface{}) bool {
-				isActive := value.(bool)
-				conn := key.(net.Conn)
-				if isActive {
-					n.requestBlockchain(conn)
-					return false
-				}
-				return true
-			})
-		} else {
-			n.blockchain.AddBlock(b)
-		}
-	} else {
+func (n *Node) validateBlock(b *blockchain.Block, conn net.Conn) {
+	connected, disconnected, err := n.blockchain.AddBlock(b)
+	switch {
+	case err == nil:
+		log.Println("Received block validated and added to the block tree")
+		n.updateMempool(connected, disconnected)
+	case errors.Is(err, blockchain.ErrOrphanBlock):
+		log.Println("Received block extends an unknown block, requesting bc...")
+		n.requestBlockchain(conn)
+	default:
 		log.Println("Received block not valid, ignoring...")
+		log.Println(err)
 	}
 }
 
 func (n *Node) validateBlockchain(bc *blockchain.Blockchain) {
-	if bc.GetLastBlock().Index > n.blockchain.GetLastBlock().Index {
-		log.Println("Received blockchain has higher index, validating blockchain...")
-		if bc.IsValid() {
-			log.Println("Received blockchain is longer and valid, replacing blockchain...")
-			n.blockchain.ReplaceChain(bc)
-		} else {
-			log.Println("Received blockchain is invalid! ignoring...")
-		}
-	} else {
-		log.Println("Received blockchain has lower or equal index, ignoring...")
+	if len(bc.Chain) == 0 || !bc.IsValid(n.params) {
+		log.Println("Received blockchain is invalid! ignoring...")
+		return
+	}
+	if bc.ChainWork(n.params).Cmp(n.blockchain.ChainWork(n.params)) <= 0 {
+		log.Println("Received blockchain has lower or equal work, ignoring...")
+		return
+	}
+
+	log.Println("Received blockchain has more work and is valid, adding its blocks...")
+	connected, disconnected, err := n.blockchain.ReplaceChain(bc)
+	if err != nil {
+		log.Println("Error while adding received blockchain")
+		log.Println(err)
 	}
+	n.updateMempool(connected, disconnected)
 }
 
 func (n *Node) getBlockchainAsMessage() (*Message, error) {
@@ -310,6 +496,22 @@ func (n *Node) getBlockchainAsMessage() (*Message, error) {
 	return nil, err
 }
 
+func (n *Node) sendVersion(conn net.Conn) {
+	versionData, err := json.Marshal(&Version{
+		Magic:       n.params.Magic,
+		GenesisHash: n.params.GenesisHash,
+	})
+	if err != nil {
+		log.Println("Error while encoding version")
+		log.Println(err)
+		return
+	}
+	n.send(conn, &Message{
+		Rpc:  "version",
+		JSON: versionData,
+	})
+}
+
 func (n *Node) requestBlockchain(conn net.Conn) {
 	msg := &Message{
 		Rpc:  "blockchainrequest",
@@ -318,7 +520,7 @@ func (n *Node) requestBlockchain(conn net.Conn) {
 	n.send(conn, msg)
 }
 
-func (n *Node) Init(target *string, keys *string) {
+func (n *Node) Init(target *string, keys *string, dataDir *string) {
 	listener, err := net.Listen("tcp4", fmt.Sprintf(":%d", n.listenPort))
 	if err != nil {
 		log.Println("Error starting listener")
@@ -357,8 +559,17 @@ func (n *Node) Init(target *string, keys *string) {
 		Address: hex.EncodeToString(n.sigTree.GetPublicKey()),
 		Balance: 0,
 	}
-	n.blockchain.Init(n.account)
-	n.mempool = make(map[string]blockchain.Transaction)
+	store, err := blockchain.OpenStore(*dataDir)
+	if err != nil {
+		log.Fatalf("Error opening data directory %s : %v", *dataDir, err)
+	}
+	err = n.blockchain.Open(store, n.params)
+	if err != nil {
+		log.Fatalf("Error loading blockchain from %s : %v", *dataDir, err)
+	}
+	n.dataDir = *dataDir
+	n.mempool = mempool.New(n.blockchain, n.params, mempool.DefaultConfig)
+	n.loadMempool()
 	if *target != "" {
 		log.Printf("Trying to add peer %s", *target)
 		conn, err := net.DialTimeout("tcp", *target, DIALTIMEOUT)
@@ -366,9 +577,10 @@ func (n *Node) Init(target *string, keys *string) {
 			log.Printf("Error dialing target %s", *target)
 			log.Println(err)
 		}
-		n.peers.Store(conn, true)
+		n.peers.Store(conn, false)
 		log.Printf("Added peer %s", *target)
 		go n.handle(conn)
+		n.sendVersion(conn)
 		n.requestBlockchain(conn)
 	}
 	n.address = listener.Addr().String()
@@ -391,13 +603,49 @@ func (n *Node) Init(target *string, keys *string) {
 	}
 }
 
+// loadMempool puts back the transactions saved at the last shutdown that are still valid on the tip.
+func (n *Node) loadMempool() {
+	txns, err := mempool.Load(filepath.Join(n.dataDir, mempoolFile))
+	if err != nil {
+		log.Println("Error loading saved mempool")
+		log.Println(err)
+		return
+	}
+	for i := range txns {
+		if n.validateTransaction(&txns[i]) == nil {
+			n.addToMempool(&txns[i])
+		}
+	}
+	count, _ := n.mempool.Size()
+	log.Printf("Loaded %d of %d saved mempool transactions", count, len(txns))
+}
+
+// Shutdown stops mining and the pool, saves the mempool and closes the store. The node must not be used afterwards.
+func (n *Node) Shutdown() {
+	n.miner.Stop()
+	if p := n.getPool(); p != nil {
+		p.Close()
+	}
+	count, _ := n.mempool.Size()
+	log.Printf("Saving %d mempool transactions...", count)
+	if err := n.mempool.Save(filepath.Join(n.dataDir, mempoolFile)); err != nil {
+		log.Println("Error saving mempool")
+		log.Println(err)
+	}
+	if err := n.blockchain.Close(); err != nil {
+		log.Println("Error closing store")
+		log.Println(err)
+	}
+}
+
 func (n *Node) simulateLocalTxns() {
 	time.Sleep(time.Second)
 	log.Println("Simulating local txns...")
 	t := &blockchain.Transaction{
 		Sender:    hex.EncodeToString(n.sigTree.GetPublicKey()),
-		Receiver:  "a33e14a8aa2522b758823536c05c894199feeb3b49ca98650edf3fee2336fb2e",
-		Amount:    1,
+		Outputs:   []blockchain.Output{{Address: "a33e14a8aa2522b758823536c05c894199feeb3b49ca98650edf3fee2336fb2e", Amount: 1}},
+		Fee:       n.params.MinTxFee,
+		Nonce:     n.blockchain.GetAccount(hex.EncodeToString(n.sigTree.GetPublicKey())).Nonce,
 		Timestamp: time.Now(),
 	}
 	t.Hash = t.ComputeHash()
@@ -410,7 +658,7 @@ func (n *Node) simulateLocalTxns() {
 		log.Println("Error encoding transaction data")
 		log.Println(err)
 	}
-	n.transactionRequestHandler(transactionData)
+	n.transactionRequestHandler(nil, transactionData)
 }
 
 //send txn request to send one coin to target, 5 times, to each peer
@@ -425,8 +673,9 @@ func (n *Node) simulateTxnRq() {
 			if isValid {
 				t := &blockchain.Transaction{
 					Sender:    hex.EncodeToString(n.sigTree.GetPublicKey()),
-					Receiver:  "a33e14a8aa2522b758823536c05c894199feeb3b49ca98650edf3fee2336fb2e",
-					Amount:    1,
+					Outputs:   []blockchain.Output{{Address: "a33e14a8aa2522b758823536c05c894199feeb3b49ca98650edf3fee2336fb2e", Amount: 1}},
+					Fee:       n.params.MinTxFee,
+					Nonce:     n.blockchain.GetAccount(hex.EncodeToString(n.sigTree.GetPublicKey())).Nonce,
 					Timestamp: time.Now(),
 				}
 				t.Hash = t.ComputeHash()
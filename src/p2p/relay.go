@@ -0,0 +1,197 @@
+package p2p
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_INV_SIZE      = 1000             // hashes in an inv or getdata message
+	KNOWN_CACHE_SIZE  = 5000             // hashes remembered per peer
+	REJECT_CACHE_SIZE = 10000            // rejected hashes remembered until the next block
+	REQUEST_TIMEOUT   = time.Second * 30 // before a transaction is asked to the next peer that announced it
+	MAX_REQUESTS      = 5000             // transactions requested and not received yet
+	MAX_PEER_REQUESTS = MAX_INV_SIZE     // of them asked to a single peer
+)
+
+// hashCache remembers up to size hashes, forgetting the oldest first. It is safe for concurrent use.
+type hashCache struct {
+	mutex  sync.Mutex
+	size   int
+	hashes map[string]bool
+	order  []string
+}
+
+func newHashCache(size int) *hashCache {
+	return &hashCache{size: size, hashes: make(map[string]bool)}
+}
+
+// Add remembers hash and reports whether it was unknown.
+func (c *hashCache) Add(hash string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.hashes[hash] {
+		return false
+	}
+	if len(c.order) >= c.size {
+		delete(c.hashes, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.hashes[hash] = true
+	c.order = append(c.order, hash)
+	return true
+}
+
+func (c *hashCache) Has(hash string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.hashes[hash]
+}
+
+func (c *hashCache) Reset() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.hashes = make(map[string]bool)
+	c.order = nil
+}
+
+// knownBy returns the hashes conn announced or was sent.
+func (n *Node) knownBy(conn net.Conn) *hashCache {
+	known, _ := n.known.LoadOrStore(conn, newHashCache(KNOWN_CACHE_SIZE))
+	return known.(*hashCache)
+}
+
+// removePeer closes conn and forgets about it.
+func (n *Node) removePeer(conn net.Conn) {
+	conn.Close()
+	n.peers.Delete(conn)
+	n.known.Delete(conn)
+}
+
+// request tracks a transaction asked for with getdata.
+type request struct {
+	announcers []net.Conn // peers that announced the transaction, the first one was asked for it
+	asked      time.Time
+}
+
+// wanted reports whether the transaction with the given hash is worth asking conn for, and if so marks it as
+// requested. Otherwise conn is remembered as an announcer to ask if the pending request times out.
+// Hashes beyond MAX_REQUESTS, or beyond MAX_PEER_REQUESTS for conn, are ignored : the transaction will be
+// announced again. Must not be called with n.mutex held.
+func (n *Node) wanted(hash string, conn net.Conn, now time.Time) bool {
+	if n.rejected.Has(hash) || n.mempool.Has(hash) {
+		return false
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if r, exists := n.requested[hash]; exists {
+		for _, announcer := range r.announcers {
+			if announcer == conn {
+				return false
+			}
+		}
+		r.announcers = append(r.announcers, conn)
+		return false
+	}
+	if len(n.requested) >= MAX_REQUESTS || n.asking[conn] >= MAX_PEER_REQUESTS {
+		return false
+	}
+	n.requested[hash] = &request{announcers: []net.Conn{conn}, asked: now}
+	n.asking[conn]++
+	time.AfterFunc(REQUEST_TIMEOUT, func() { n.retryRequest(hash) })
+	return true
+}
+
+// retryRequest asks the next announcer for the transaction with the given hash if it still hasn't arrived,
+// skipping the peers that disconnected since.
+func (n *Node) retryRequest(hash string) {
+	n.mutex.Lock()
+	r, exists := n.requested[hash]
+	if !exists || time.Since(r.asked) < REQUEST_TIMEOUT {
+		n.mutex.Unlock()
+		return
+	}
+	n.answered(r.announcers[0])
+	r.announcers = r.announcers[1:]
+	for len(r.announcers) > 0 {
+		if _, connected := n.peers.Load(r.announcers[0]); connected {
+			break
+		}
+		r.announcers = r.announcers[1:]
+	}
+	if len(r.announcers) == 0 {
+		delete(n.requested, hash)
+		n.mutex.Unlock()
+		return
+	}
+	r.asked = time.Now()
+	conn := r.announcers[0]
+	n.asking[conn]++
+	n.mutex.Unlock()
+
+	time.AfterFunc(REQUEST_TIMEOUT, func() { n.retryRequest(hash) })
+	n.sendHashes(conn, "getdata", &Inventory{Hashes: []string{hash}})
+}
+
+// received forgets that the transaction with the given hash was requested.
+func (n *Node) received(hash string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if r, exists := n.requested[hash]; exists {
+		n.answered(r.announcers[0])
+		delete(n.requested, hash)
+	}
+}
+
+// answered stops counting a request to conn, which is over. Must be called with n.mutex held.
+func (n *Node) answered(conn net.Conn) {
+	if n.asking[conn]--; n.asking[conn] <= 0 {
+		delete(n.asking, conn)
+	}
+}
+
+// announce sends an inv of the given transaction hashes to every peer that doesn't know them yet.
+func (n *Node) announce(hashes ...string) {
+	n.peers.Range(func(k, v interface{}) bool {
+		conn := k.(net.Conn)
+		if v.(bool) {
+			n.sendInventory(conn, hashes)
+		}
+		return true
+	})
+}
+
+// sendInventory sends an inv of the given transaction hashes conn doesn't know yet.
+func (n *Node) sendInventory(conn net.Conn, hashes []string) {
+	known := n.knownBy(conn)
+	inv := &Inventory{}
+	for _, hash := range hashes {
+		if known.Add(hash) {
+			inv.Hashes = append(inv.Hashes, hash)
+		}
+	}
+	for len(inv.Hashes) > 0 {
+		batch := &Inventory{Hashes: inv.Hashes}
+		if len(batch.Hashes) > MAX_INV_SIZE {
+			batch.Hashes = batch.Hashes[:MAX_INV_SIZE]
+		}
+		inv.Hashes = inv.Hashes[len(batch.Hashes):]
+		n.sendHashes(conn, "inv", batch)
+	}
+}
+
+func (n *Node) sendHashes(conn net.Conn, rpc string, inv *Inventory) {
+	invData, err := json.Marshal(inv)
+	if err != nil {
+		log.Printf("Error while encoding %s", rpc)
+		log.Println(err)
+		return
+	}
+	n.send(conn, &Message{
+		Rpc:  rpc,
+		JSON: invData,
+	})
+}
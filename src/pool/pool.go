@@ -0,0 +1,341 @@
+// Package pool hands out mining work to remote workers over a Stratum-like protocol and counts their shares.
+//
+// Messages are JSON objects, one per line. Workers send Requests, answered by a Response with the same ID :
+//
+//	mining.subscribe  no params, answered with a Subscription
+//	mining.authorize  params {"Worker": name}, answered with true
+//	mining.submit     params a Share, answered with true, or false and an Error
+//
+// Once subscribed, the server sends a mining.notify Request without ID holding a Job every time the work changes.
+// A worker looks for a nonce whose upper 32 bits are its ExtraNonce, making the hash of the Job's block start
+// with the Job's ShareTarget. Shares also meeting the target of the consensus engine are submitted to the node.
+package pool
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"ketcoin/src/blockchain"
+	"ketcoin/src/consensus"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MAX_JOBS      = 8 // jobs on the same tip shares are accepted for
+	WRITE_TIMEOUT = time.Second * 10
+)
+
+var (
+	ErrUnknownJob    = errors.New("unknown or stale job")
+	ErrUnauthorized  = errors.New("unauthorized worker")
+	ErrNonceRange    = errors.New("nonce outside of the extra nonce range")
+	ErrDuplicate     = errors.New("duplicate share")
+	ErrLowDifficulty = errors.New("share above target")
+)
+
+type Request struct {
+	ID     uint64
+	Method string
+	Params json.RawMessage
+}
+
+type Response struct {
+	ID     uint64
+	Result interface{}
+	Error  string `json:",omitempty"`
+}
+
+// Subscription answers a mining.subscribe.
+type Subscription struct {
+	ExtraNonce uint32
+}
+
+// Job is the work sent with a mining.notify.
+type Job struct {
+	ID          string
+	Block       *blockchain.Block
+	ShareTarget string // prefix of the hash of a share
+	Clean       bool   // previous jobs are stale
+}
+
+// Share is the params of a mining.submit.
+type Share struct {
+	Worker string
+	JobID  string
+	Nonce  int
+}
+
+// WorkerStats counts the accepted shares of a worker and the blocks it found.
+type WorkerStats struct {
+	Shares uint64
+	Blocks uint64
+}
+
+// Server is a pool server. It is safe for concurrent use.
+type Server struct {
+	mutex           sync.Mutex
+	backend         consensus.Backend
+	engine          consensus.Targeter
+	shareDifficulty int
+	listener        net.Listener
+	jobs            map[string]*job
+	order           []string // job IDs, oldest first
+	nextJob         uint64
+	clients         map[*client]bool
+	nextExtraNonce  uint32
+	workers         map[string]*WorkerStats
+}
+
+type job struct {
+	block  *blockchain.Block
+	shares map[int]bool // submitted nonces
+}
+
+type client struct {
+	conn       net.Conn
+	mutex      sync.Mutex // serializes writes
+	extraNonce uint32
+	subscribed bool
+	authorized map[string]bool
+}
+
+// New returns a pool server accepting shares meeting the share target of engine for shareDifficulty.
+func New(backend consensus.Backend, engine consensus.Targeter, shareDifficulty int) *Server {
+	return &Server{
+		backend:         backend,
+		engine:          engine,
+		shareDifficulty: shareDifficulty,
+		jobs:            make(map[string]*job),
+		clients:         make(map[*client]bool),
+		workers:         make(map[string]*WorkerStats),
+	}
+}
+
+// Listen accepts workers on addr until Close is called.
+func (s *Server) Listen(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	log.Printf("Pool listening on address : %s", listener.Addr())
+	s.Refresh()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Println("Pool stopped accepting workers")
+				log.Println(err)
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	return nil
+}
+
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// Refresh builds a new job from the block template and sends it to the subscribed workers.
+// Without template, the jobs are dropped and workers wait for the next one.
+func (s *Server) Refresh() {
+	b := s.backend.BlockTemplate()
+	if b == nil {
+		// shares for the current jobs may not be worth anything anymore
+		s.mutex.Lock()
+		s.jobs = make(map[string]*job)
+		s.order = nil
+		s.mutex.Unlock()
+		return
+	}
+
+	s.mutex.Lock()
+	clean := len(s.order) == 0 || s.jobs[s.order[len(s.order)-1]].block.PrevHash != b.PrevHash
+	if clean {
+		s.jobs = make(map[string]*job)
+		s.order = nil
+	} else if len(s.order) == MAX_JOBS {
+		delete(s.jobs, s.order[0])
+		s.order = s.order[1:]
+	}
+	s.nextJob++
+	id := strconv.FormatUint(s.nextJob, 16)
+	s.jobs[id] = &job{block: b, shares: make(map[int]bool)}
+	s.order = append(s.order, id)
+	notify := s.notification(id, clean)
+	clients := make([]*client, 0, len(s.clients))
+	for c := range s.clients {
+		if c.subscribed {
+			clients = append(clients, c)
+		}
+	}
+	s.mutex.Unlock()
+
+	for _, c := range clients {
+		c.send(notify)
+	}
+}
+
+// Stats returns the statistics of every worker that submitted a share, by name.
+func (s *Server) Stats() map[string]WorkerStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	stats := make(map[string]WorkerStats, len(s.workers))
+	for name, w := range s.workers {
+		stats[name] = *w
+	}
+	return stats
+}
+
+// notification returns the mining.notify of the job with the given id. Must be called with s.mutex held.
+func (s *Server) notification(id string, clean bool) *Request {
+	b := s.jobs[id].block
+	params, _ := json.Marshal(&Job{
+		ID:          id,
+		Block:       b,
+		ShareTarget: s.engine.ShareTarget(b, s.shareDifficulty),
+		Clean:       clean,
+	})
+	return &Request{Method: "mining.notify", Params: params}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	c := &client{conn: conn, authorized: make(map[string]bool)}
+	s.mutex.Lock()
+	c.extraNonce = s.nextExtraNonce
+	s.nextExtraNonce++
+	s.clients[c] = true
+	s.mutex.Unlock()
+	log.Printf("Pool worker connected from %s", conn.RemoteAddr())
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		request := &Request{}
+		if err := json.Unmarshal(scanner.Bytes(), request); err != nil {
+			log.Printf("Error decoding request from pool worker %s", conn.RemoteAddr())
+			log.Println(err)
+			break
+		}
+		s.serve(c, request)
+	}
+
+	s.mutex.Lock()
+	delete(s.clients, c)
+	s.mutex.Unlock()
+	conn.Close()
+	log.Printf("Pool worker %s disconnected", conn.RemoteAddr())
+}
+
+func (s *Server) serve(c *client, request *Request) {
+	response := &Response{ID: request.ID}
+	switch request.Method {
+	case "mining.subscribe":
+		s.mutex.Lock()
+		c.subscribed = true
+		var notify *Request
+		if len(s.order) > 0 {
+			notify = s.notification(s.order[len(s.order)-1], true)
+		}
+		s.mutex.Unlock()
+		response.Result = &Subscription{ExtraNonce: c.extraNonce}
+		c.send(response)
+		if notify != nil {
+			c.send(notify)
+		}
+		return
+	case "mining.authorize":
+		params := &struct{ Worker string }{}
+		if err := json.Unmarshal(request.Params, params); err != nil || params.Worker == "" {
+			response.Result, response.Error = false, "invalid worker name"
+			break
+		}
+		s.mutex.Lock()
+		c.authorized[params.Worker] = true
+		s.mutex.Unlock()
+		response.Result = true
+	case "mining.submit":
+		share := &Share{}
+		err := json.Unmarshal(request.Params, share)
+		if err == nil {
+			err = s.submit(c, share)
+		}
+		response.Result = err == nil
+		if err != nil {
+			response.Error = err.Error()
+		}
+	default:
+		response.Error = "unknown method " + request.Method
+	}
+	c.send(response)
+}
+
+// submit checks and counts a share, submitting its block to the node if it meets the block difficulty.
+func (s *Server) submit(c *client, share *Share) error {
+	s.mutex.Lock()
+	j, exists := s.jobs[share.JobID]
+	switch {
+	case !c.authorized[share.Worker]:
+		s.mutex.Unlock()
+		return ErrUnauthorized
+	case !exists:
+		s.mutex.Unlock()
+		return ErrUnknownJob
+	case uint64(share.Nonce)>>32 != uint64(c.extraNonce):
+		s.mutex.Unlock()
+		return ErrNonceRange
+	case j.shares[share.Nonce]:
+		s.mutex.Unlock()
+		return ErrDuplicate
+	}
+	b := *j.block
+	b.Nonce = share.Nonce
+	b.Hash = b.ComputeHash()
+	if !strings.HasPrefix(b.Hash, s.engine.ShareTarget(&b, s.shareDifficulty)) {
+		s.mutex.Unlock()
+		return ErrLowDifficulty
+	}
+	j.shares[share.Nonce] = true
+	w, exists := s.workers[share.Worker]
+	if !exists {
+		w = &WorkerStats{}
+		s.workers[share.Worker] = w
+	}
+	w.Shares++
+	s.mutex.Unlock()
+
+	if strings.HasPrefix(b.Hash, s.engine.Target(&b)) {
+		log.Printf("Pool worker %s found block %s!", share.Worker, b.Hash)
+		if s.backend.SubmitBlock(&b) {
+			s.mutex.Lock()
+			w.Blocks++
+			s.mutex.Unlock()
+		}
+	}
+	return nil
+}
+
+func (c *client) send(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Error encoding pool message")
+		log.Println(err)
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.conn.SetWriteDeadline(time.Now().Add(WRITE_TIMEOUT))
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		log.Printf("Error sending pool message to %s", c.conn.RemoteAddr())
+		log.Println(err)
+	}
+}